@@ -0,0 +1,172 @@
+package gian
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+)
+
+// RecordWriter is an io.Writer committing what is written to it as records
+// of a Gian, so it can back log.New, slog handlers, json.Encoder and
+// io.Copy. A record is on both files once the call writing its end
+// returns.
+type RecordWriter struct {
+	mu      sync.Mutex
+	g       *Gian
+	lines   bool
+	partial []byte // start of a line waiting for its newline
+}
+
+// NewWriter returns a RecordWriter committing each Write call as a record
+func (g *Gian) NewWriter() *RecordWriter {
+	return &RecordWriter{g: g}
+}
+
+// NewLineWriter returns a RecordWriter committing each line, newline
+// included, as a record. A line without its newline waits for the next
+// Write, Flush or Close.
+func (g *Gian) NewLineWriter() *RecordWriter {
+	return &RecordWriter{g: g, lines: true}
+}
+
+func (w *RecordWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.write(p)
+}
+
+func (w *RecordWriter) write(p []byte) (int, error) {
+	if !w.lines {
+		if len(p) == 0 {
+			return 0, nil
+		}
+		if _, err := w.g.Append(p); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	n := 0
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.partial = append(w.partial, p...)
+			return n + len(p), nil
+		}
+		line := p[:i+1]
+		if len(w.partial) > 0 {
+			line = append(w.partial, line...)
+		}
+		if _, err := w.g.Append(line); err != nil {
+			return n, err
+		}
+		w.partial = w.partial[:0]
+		n += i + 1
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// ReadFrom commits everything read from r. A line writer commits each line
+// as a record, otherwise the whole of r becomes one record, see
+// AppendStream.
+func (w *RecordWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.lines {
+		counter := &countingReader{r: r}
+		_, err := w.g.AppendStream(counter)
+		return counter.n, err
+	}
+
+	buf := make([]byte, 32*1024)
+	total := int64(0)
+	for {
+		n, rerr := r.Read(buf)
+		if n > 0 {
+			m, err := w.write(buf[:n])
+			total += int64(m)
+			if err != nil {
+				return total, err
+			}
+		}
+		if rerr == io.EOF {
+			return total, nil
+		}
+		if rerr != nil {
+			return total, rerr
+		}
+	}
+}
+
+// Flush commits the line waiting for its newline, if any
+func (w *RecordWriter) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.partial) == 0 {
+		return nil
+	}
+	if _, err := w.g.Append(w.partial); err != nil {
+		return err
+	}
+	w.partial = w.partial[:0]
+	return nil
+}
+
+// Close flushes the writer, the Gian stays open
+func (w *RecordWriter) Close() error {
+	return w.Flush()
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// WriteTo writes the payload of every record to w, oldest first and
+// without framing, so the output of a RecordWriter comes back as it was
+// written. With ReadPending the pending data comes last. Frames are
+// verified and repaired like in OpenRecord, one at a time.
+func (g *Gian) WriteTo(w io.Writer) (int64, error) {
+	ctx := context.Background()
+	g.mu.Lock()
+	end, top, err := g.snapshot(ctx)
+	fixes, trunc := g.fixes, g.truncations.Load()
+	var pending []byte
+	if err == nil && g.isolation == ReadPending && g.uncommitLength > 0 {
+		pending = bytes.Clone(g.uncommitBuffer[:g.uncommitLength])
+	}
+	g.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+
+	total := int64(0)
+	if top > 0 {
+		r, err := g.openRecordReader(ctx, end, fixes, trunc, 1, top)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+		if total, err = io.Copy(w, r); err != nil {
+			return total, err
+		}
+	}
+	if len(pending) > 0 {
+		n, err := w.Write(pending)
+		total += int64(n)
+		if err != nil {
+			return total, err
+		}
+		g.stats.read(1)
+	}
+	return total, nil
+}
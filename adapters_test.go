@@ -0,0 +1,88 @@
+package gian
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecordWriter(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_record_writer_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+
+	logger := log.New(gian.NewWriter(), "", 0)
+	logger.Print("one")
+	logger.Print("two")
+	if index, _ := gian.CommittedIndex(); index != 2 {
+		t.Errorf("SHOULDEQ 2, got %d", index)
+	}
+	out, err := gian.ReadAll()
+	if err != nil || string(out) != "two\none\n" {
+		t.Errorf("SHOULDEQ, got %q %v", out, err)
+	}
+
+	w := gian.NewLineWriter()
+	slog.New(slog.NewTextHandler(w, nil)).Info("three")
+	json.NewEncoder(w).Encode(map[string]int{"four": 4})
+	io.WriteString(w, "five\nsi")
+	io.WriteString(w, "x")
+	if index, _ := gian.CommittedIndex(); index != 5 {
+		t.Errorf("SHOULDEQ 5, got %d", index)
+	}
+	w.Close()
+
+	r, _ := gian.NewReader()
+	defer r.Close()
+	for _, want := range []string{"six", "five\n", "{\"four\":4}\n"} {
+		if out, err := r.Read(); err != nil || string(out) != want {
+			t.Errorf("SHOULDEQ %q, got %q %v", want, out, err)
+		}
+	}
+	if out, _ := r.Read(); !strings.HasSuffix(string(out), "msg=three\n") {
+		t.Errorf("SHOULD BE THE SLOG LINE, got %q", out)
+	}
+}
+
+func TestReadFromWriteTo(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_read_from_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+
+	lines := strings.Repeat("a line of the log\n", 1000)
+	if n, err := io.Copy(gian.NewLineWriter(), strings.NewReader(lines)); err != nil || n != int64(len(lines)) {
+		t.Fatalf("SHOULDEQ %d, got %d %v", len(lines), n, err)
+	}
+	if index, _ := gian.CommittedIndex(); index != 1000 {
+		t.Errorf("SHOULDEQ 1000, got %d", index)
+	}
+
+	blob := bytes.Repeat([]byte("blob"), STREAM_CHUNKSIZE)
+	if n, err := gian.NewWriter().ReadFrom(bytes.NewReader(blob)); err != nil || n != int64(len(blob)) {
+		t.Fatalf("SHOULDEQ %d, got %d %v", len(blob), n, err)
+	}
+	if index, _ := gian.CommittedIndex(); index != 1004 {
+		t.Errorf("SHOULD BE ONE RECORD OF 4 FRAMES, got %d", index)
+	}
+	gian.Write([]byte("pending"))
+
+	out := &bytes.Buffer{}
+	n, err := gian.WriteTo(out)
+	want := lines + string(blob) + "pending"
+	if err != nil || n != int64(len(want)) || out.String() != want {
+		t.Errorf("SHOULDEQ %d, got %d %v", len(want), n, err)
+	}
+}
@@ -0,0 +1,170 @@
+package gian
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"time"
+)
+
+// BATCHED is set in the index field of every frame of a batch but the
+// last, see AppendBatch
+const BATCHED = 1 << 63
+
+// AppendBatch commits records as one unit, after a crash either all of them
+// are there or none. Each record keeps an index of its own, the index of
+// the first one is returned.
+func (g *Gian) AppendBatch(records [][]byte) (uint64, error) {
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.forceCommit(context.Background()); err != nil {
+		return 0, err
+	}
+	if err := g.load(context.Background()); err != nil {
+		return 0, err
+	}
+	frames := make([][]byte, len(records))
+	flags := make([]uint64, len(records))
+	sizes := make([]int, len(records))
+	for i, data := range records {
+		var err error
+		if frames[i], flags[i], err = g.stampFrame(data); err != nil {
+			return 0, err
+		}
+		sizes[i] = len(data)
+	}
+	return g.writeUnit(frames, flags, sizes)
+}
+
+// writeUnit writes frames as the next records in one write to each file,
+// BATCHED set on all but the last, flags are the other flags of each frame
+// and sizes the payloads reported to the observer. It returns the index of
+// the first record.
+func (g *Gian) writeUnit(frames [][]byte, flags []uint64, sizes []int) (uint64, error) {
+	if err := g.openWriters(); err != nil {
+		return 0, err
+	}
+
+	size := 0
+	for _, frame := range frames {
+		size += 8 + 4 + len(frame) + 4 + 4
+	}
+	buf := make([]byte, 0, size)
+	first := g.lastWriteIndex + 1
+	checksums := make([]uint32, len(frames))
+	checksum := g.lastCheckSum
+	for i, frame := range frames {
+		index := uint64(first+i) | flags[i]
+		if i < len(frames)-1 {
+			index |= BATCHED
+		}
+		buf, checksum = encodeFrame(buf, checksum, index, frame, uint32(len(frame)))
+		checksums[i] = checksum
+	}
+
+	start := time.Now()
+	if _, err := g.wfile.Write(buf); err != nil {
+		// the batch may be torn, the next load drops it
+		g.loaded = false
+		return 0, err
+	}
+	if _, err := g.wbakfile.Write(buf); err != nil {
+		g.loaded = false
+		return 0, err
+	}
+	if g.fsync {
+		if err := g.wfile.Sync(); err != nil {
+			return 0, err
+		}
+		g.stats.fsync()
+		if err := g.wbakfile.Sync(); err != nil {
+			return 0, err
+		}
+		g.stats.fsync()
+	}
+
+	latency := time.Since(start)
+	for i := range frames {
+		g.stats.commit(latency)
+		g.committed(first+i, checksums[i], sizes[i])
+	}
+	return uint64(first), nil
+}
+
+// frameIndex splits an index field into the index and its flags, BATCHED
+// and HEADERS
+func frameIndex(indexb []byte) (int, uint64) {
+	i := binary.BigEndian.Uint64(indexb)
+	return int(i &^ (BATCHED | HEADERS)), i & (BATCHED | HEADERS)
+}
+
+// dropUnfinished cuts filename before the frames of a unit, a streamed
+// record or a batch, that never got its last frame. It was never
+// acknowledged.
+func dropUnfinished(filename string) error {
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	start, err := unitBoundary(f, fi.Size())
+	if err != nil || start == fi.Size() {
+		return err
+	}
+	return truncateFile(filename, start)
+}
+
+// unitBoundary walks back from end over frames carrying CONTINUED or
+// BATCHED and returns where the first of them starts, end if the last
+// frame ends a unit
+func unitBoundary(f io.ReaderAt, end int64) (int64, error) {
+	for end > 0 {
+		start, index, err := frameBefore(f, end)
+		if err != nil {
+			return 0, err
+		}
+		_, flags := frameIndex(index[:])
+		_, continued := frameLength(index[8:])
+		if !continued && flags&BATCHED == 0 {
+			return end, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// frameBefore returns where the frame ending at end starts, with its index
+// and length fields
+func frameBefore(f io.ReaderAt, end int64) (int64, [12]byte, error) {
+	// [ N ] [ Length ] [ --- data ---- ] [ Length ] [ CHECKSUM ]
+	head := [12]byte{}
+	if end < 8+4+4+4 {
+		return 0, head, &CorruptionError{Kind: CorruptTruncated, Offset: 0}
+	}
+	if _, err := f.ReadAt(head[8:], end-8); err != nil {
+		return 0, head, err
+	}
+	l, _ := frameLength(head[8:])
+	start := end - (8 + 4 + int64(l) + 4 + 4)
+	if l > ONEGB || start < 0 {
+		return 0, head, &CorruptionError{Kind: CorruptWrongLength, Offset: end}
+	}
+	if _, err := f.ReadAt(head[:8], start); err != nil {
+		return 0, head, err
+	}
+	return start, head, nil
+}
@@ -0,0 +1,82 @@
+package gian
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestAppendBatch(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_batch_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	gian.Append([]byte("order"))
+	index, err := gian.AppendBatch([][]byte{[]byte("order 2"), []byte("item a"), []byte("item b")})
+	if err != nil || index != 2 {
+		t.Fatalf("SHOULDEQ 2, got %d %v", index, err)
+	}
+	if index, _ := gian.Append([]byte("order 3")); index != 5 {
+		t.Errorf("SHOULDEQ 5, got %d", index)
+	}
+	if index, err := ReadFromStart(filename, nil); err != nil || index != 5 {
+		t.Errorf("SHOULDEQ 5, got %d %v", index, err)
+	}
+	out, err := gian.ReadAll()
+	if err != nil || string(out) != "order 3item bitem aorder 2order" {
+		t.Errorf("SHOULDEQ, got %s %v", out, err)
+	}
+	if err := gian.TruncateTo(3); err == nil {
+		t.Errorf("MUST NOT CUT A BATCH")
+	}
+}
+
+func TestTornBatch(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_batch_torn_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	gian.Append([]byte("before"))
+	gian.AppendBatch([][]byte{[]byte("one"), []byte("two"), []byte("three")})
+	gian.Close()
+	size := fileSize(filename)
+
+	// the last frame of the batch never made it to main, the backup is
+	// torn in the middle of a frame
+	cutFileTail(filename, 5+20)
+	cutFileTail(filename+".bak", 5+20+10)
+
+	buf := &bytes.Buffer{}
+	if index, err := ReadFromStart(filename, buf); err != nil || index != 1 {
+		t.Errorf("SHOULDEQ 1, got %d %v", index, err)
+	}
+	if buf.Len() != 6+20 {
+		t.Errorf("MUST NOT EXPOSE THE BATCH, got %d bytes", buf.Len())
+	}
+
+	gian = New(filename)
+	defer gian.Close()
+	r, err := gian.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if out, err := r.Read(); err != nil || string(out) != "before" {
+		t.Errorf("SHOULDEQ before, got %s %v", out, err)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("SHOULD BE EOF, got %v", err)
+	}
+	if index, err := gian.Append([]byte("after")); err != nil || index != 2 {
+		t.Errorf("SHOULDEQ 2, got %d %v", index, err)
+	}
+	if fileSize(filename) >= size || checkSumFile(filename) != checkSumFile(filename+".bak") {
+		t.Errorf("MUST DROP THE BATCH FROM BOTH FILES")
+	}
+}
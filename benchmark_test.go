@@ -67,3 +67,37 @@ func BenchmarkRead(b *testing.B) {
 		g2.Close()
 	}
 }
+
+func BenchmarkConcurrentWrite(b *testing.B) {
+	file, _ := os.CreateTemp("", "bench_concurrent_write_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	g := NewWithOptions(filename, Options{Fsync: true})
+	data := []byte("hello")
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			g.Append(data)
+		}
+	})
+	g.Close()
+}
+
+func BenchmarkConcurrentWriteGroupCommit(b *testing.B) {
+	file, _ := os.CreateTemp("", "bench_concurrent_write_group_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	g := NewWithOptions(filename, Options{GroupCommit: true, Fsync: true})
+	data := []byte("hello")
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			g.Append(data)
+		}
+	})
+	g.Close()
+}
@@ -0,0 +1,173 @@
+package gian
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"iter"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CHECKPOINT_KEEP is the number of checkpoints kept, the older ones are
+// removed by SaveCheckpoint
+const CHECKPOINT_KEEP = 2
+
+// Checkpoint is application state saved at a record, replaying the records
+// after Index on top of State gives the state now
+type Checkpoint struct {
+	Index uint64
+	State []byte
+}
+
+// SaveCheckpoint stores state, built from the records up to index, next to
+// the log. It is a gian log of its own, so it has a backup and heals like
+// the log, and it remembers the checksum of record index so it is only
+// used with the log it was built from. A checkpoint cut short by a crash
+// is ignored by Recover.
+func (g *Gian) SaveCheckpoint(index uint64, state []byte) error {
+	return g.SaveCheckpointContext(context.Background(), index, state)
+}
+
+func (g *Gian) SaveCheckpointContext(ctx context.Context, index uint64, state []byte) error {
+	checksum, err := g.recordChecksum(ctx, int(index))
+	if err != nil {
+		return err
+	}
+	// Move waits for the checkpoint, it goes along with the log
+	g.fmu.RLock()
+	defer g.fmu.RUnlock()
+
+	filename := g.checkpointFile(index)
+	for _, f := range []string{filename, filename + ".bak"} {
+		if err := os.Remove(f); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	cp := NewWithOptions(filename, Options{Fsync: true})
+	// [ Index ] [ CHECKSUM of the record ] then the state
+	meta := binary.BigEndian.AppendUint64(nil, index)
+	meta = binary.BigEndian.AppendUint32(meta, checksum)
+	if _, err := cp.Append(meta); err != nil {
+		cp.Close()
+		return err
+	}
+	if _, err := cp.AppendStreamContext(ctx, bytes.NewReader(state)); err != nil {
+		cp.Close()
+		return err
+	}
+	if err := cp.Close(); err != nil {
+		return err
+	}
+
+	indexes, err := g.checkpoints()
+	if err != nil {
+		return err
+	}
+	for _, old := range indexes[min(CHECKPOINT_KEEP, len(indexes)):] {
+		for _, f := range []string{g.checkpointFile(old), g.checkpointFile(old) + ".bak"} {
+			if err := os.Remove(f); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkpointFile is where the checkpoint at index is kept, the caller
+// holds fmu or mu
+func (g *Gian) checkpointFile(index uint64) string {
+	return g.filename + ".checkpoint." + strconv.FormatUint(index, 10)
+}
+
+// checkpoints returns the indexes of the checkpoints on disk, newest
+// first, the caller holds fmu or mu
+func (g *Gian) checkpoints() ([]uint64, error) {
+	prefix := filepath.Base(g.filename) + ".checkpoint."
+	entries, err := os.ReadDir(filepath.Dir(g.filename))
+	if err != nil {
+		return nil, err
+	}
+	indexes := []uint64{}
+	for _, e := range entries {
+		name, ok := strings.CutPrefix(e.Name(), prefix)
+		if !ok {
+			continue
+		}
+		if index, err := strconv.ParseUint(name, 10, 64); err == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	slices.Sort(indexes)
+	slices.Reverse(indexes)
+	return indexes, nil
+}
+
+// Recover returns the newest checkpoint that is whole and matches the log,
+// nil if there is none, and the records after it, oldest first. A damaged
+// copy of a checkpoint is healed from the other one, a checkpoint lost
+// on both is skipped for an older one.
+func (g *Gian) Recover(ctx context.Context) (*Checkpoint, iter.Seq2[*Record, error], error) {
+	g.fmu.RLock()
+	indexes, err := g.checkpoints()
+	files := make([]string, len(indexes))
+	for i, index := range indexes {
+		files[i] = g.checkpointFile(index)
+	}
+	g.fmu.RUnlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i, index := range indexes {
+		state, err := g.loadCheckpoint(ctx, files[i], index)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil, ctx.Err()
+			}
+			g.reportCorruption(files[i], err)
+			continue
+		}
+		return &Checkpoint{Index: index, State: state}, g.Records(ctx, index+1), nil
+	}
+	return nil, g.Records(ctx, 1), nil
+}
+
+// loadCheckpoint reads the state of the checkpoint at index, kept in
+// filename, once it made sure the log still has the record it was built
+// from
+func (g *Gian) loadCheckpoint(ctx context.Context, filename string, index uint64) ([]byte, error) {
+	cp := New(filename)
+	defer cp.Close()
+
+	rc, err := cp.OpenRecordContext(ctx, 1)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) != 8+4 || binary.BigEndian.Uint64(meta) != index {
+		return nil, errors.New("bad checkpoint")
+	}
+	checksum, err := g.recordChecksum(ctx, int(index))
+	if err != nil {
+		return nil, err
+	}
+	if checksum != binary.BigEndian.Uint32(meta[8:]) {
+		return nil, errors.New("checkpoint does not match the log")
+	}
+
+	rc, err = cp.OpenRecordContext(ctx, 2)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
@@ -0,0 +1,90 @@
+package gian
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func recoverState(t *testing.T, gian *Gian) (uint64, string) {
+	t.Helper()
+	cp, records, err := gian.Recover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, state := uint64(0), ""
+	if cp != nil {
+		index, state = cp.Index, string(cp.State)
+	}
+	for rec, err := range records {
+		if err != nil {
+			t.Fatal(err)
+		}
+		state += string(rec.Data)
+	}
+	return index, state
+}
+
+func TestCheckpoint(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_checkpoint_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+	defer func() {
+		matches, _ := filepath.Glob(filename + ".checkpoint.*")
+		for _, m := range matches {
+			os.Remove(m)
+		}
+	}()
+
+	gian := New(filename)
+	defer gian.Close()
+	if index, state := recoverState(t, gian); index != 0 || state != "" {
+		t.Errorf("SHOULD BE EMPTY, got %d %q", index, state)
+	}
+
+	state := ""
+	for i := 1; i <= 9; i++ {
+		gian.Append([]byte(fmt.Sprint(i)))
+		state += fmt.Sprint(i)
+		if i%3 == 0 {
+			if err := gian.SaveCheckpoint(uint64(i), []byte(state)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	gian.Append([]byte("x"))
+	if _, err := os.Stat(gian.checkpointFile(3)); !os.IsNotExist(err) {
+		t.Errorf("MUST KEEP %d CHECKPOINTS, got %v", CHECKPOINT_KEEP, err)
+	}
+	if index, got := recoverState(t, gian); index != 9 || got != "123456789x" {
+		t.Errorf("SHOULDEQ 9 123456789x, got %d %q", index, got)
+	}
+
+	// a damaged copy heals
+	f, _ := os.OpenFile(gian.checkpointFile(9), os.O_RDWR, 0644)
+	f.WriteAt([]byte("!"), 40)
+	f.Close()
+	if index, got := recoverState(t, gian); index != 9 || got != "123456789x" {
+		t.Errorf("SHOULDEQ 9 123456789x, got %d %q", index, got)
+	}
+
+	// both copies damaged, falls back to the older checkpoint
+	for _, name := range []string{gian.checkpointFile(9), gian.checkpointFile(9) + ".bak"} {
+		f, _ := os.OpenFile(name, os.O_RDWR, 0644)
+		f.WriteAt([]byte("!"), 40)
+		f.Close()
+	}
+	if index, got := recoverState(t, gian); index != 6 || got != "123456789x" {
+		t.Errorf("SHOULDEQ 6 123456789x, got %d %q", index, got)
+	}
+
+	// the log no longer has the record checkpoint 6 was built from
+	gian.TruncateTo(5)
+	gian.Append([]byte("y"))
+	if index, got := recoverState(t, gian); index != 0 || got != "12345y" {
+		t.Errorf("SHOULDEQ 0 12345y, got %d %q", index, got)
+	}
+}
@@ -0,0 +1,143 @@
+package gian
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+)
+
+// CLONE_BLOCKSIZE is the alignment of the part of a file Snapshot shares
+// with its clone, the rest is copied
+const CLONE_BLOCKSIZE = 4096
+
+// Snapshot writes a copy of the log as it is now to dstPath and
+// dstPath.bak, an independent gian pair that ends with the last record
+// committed, whose index is returned. Buffered writes are committed first,
+// later writes go on while the files are copied and do not show up in the
+// copy. On filesystems with copy on write the data is shared, not copied,
+// until either side changes. Hard links are not used, gian appends to its
+// files in place so a linked copy would grow with the log. Sidecars like
+// consumers and checkpoints are not copied.
+func (g *Gian) Snapshot(dstPath string) (uint64, error) {
+	return g.SnapshotContext(context.Background(), dstPath)
+}
+
+func (g *Gian) SnapshotContext(ctx context.Context, dstPath string) (uint64, error) {
+	for _, name := range []string{dstPath, dstPath + ".bak"} {
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			if err == nil {
+				return 0, os.ErrExist
+			}
+			return 0, err
+		}
+	}
+	for {
+		// a copy made while a fix ran may hold what it repaired, fixes are
+		// rare so the next copy is whole
+		index, err := g.snapshotTo(ctx, dstPath)
+		if !errors.Is(err, errFixedMeanwhile) {
+			return index, err
+		}
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// errFixedMeanwhile is returned by snapshotTo when the log was repaired
+// during the copy
+var errFixedMeanwhile = errors.New("gian was repaired during the copy")
+
+func (g *Gian) snapshotTo(ctx context.Context, dstPath string) (uint64, error) {
+	g.mu.Lock()
+	if err := g.forceCommit(ctx); err != nil {
+		g.mu.Unlock()
+		return 0, err
+	}
+	end, top, err := g.snapshot(ctx)
+	fixes, trunc := g.fixes, g.truncations.Load()
+	var main, bak *os.File
+	if err == nil {
+		// the files as they are now, a fix renames new ones over them and
+		// leaves these alone
+		main, bak, err = openPair(g.filename)
+	}
+	g.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	defer main.Close()
+	defer bak.Close()
+
+	makeSurePath(dstPath)
+	for _, c := range []struct {
+		src *os.File
+		dst string
+	}{{main, dstPath}, {bak, dstPath + ".bak"}} {
+		if err := copyPrefix(ctx, c.dst, c.src, end); err != nil {
+			os.Remove(dstPath)
+			os.Remove(dstPath + ".bak")
+			return 0, err
+		}
+	}
+	if g.truncations.Load() != trunc {
+		// the frames copied may have been cut while copying
+		os.Remove(dstPath)
+		os.Remove(dstPath + ".bak")
+		return 0, ErrTruncated
+	}
+	g.mu.Lock()
+	fixed := g.fixes != fixes
+	g.mu.Unlock()
+	if fixed {
+		os.Remove(dstPath)
+		os.Remove(dstPath + ".bak")
+		return 0, errFixedMeanwhile
+	}
+	return uint64(top), nil
+}
+
+// openPair opens both files of the log at filename for reading, a missing
+// one is created empty
+func openPair(filename string) (*os.File, *os.File, error) {
+	main, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	bak, err := os.OpenFile(filename+".bak", os.O_RDONLY|os.O_CREATE, 0644)
+	if err != nil {
+		main.Close()
+		return nil, nil, err
+	}
+	return main, bak, nil
+}
+
+// copyPrefix writes the first length bytes of src to a new file dst and
+// syncs it. The blocks before the last whole one are cloned when the
+// filesystem allows it.
+func copyPrefix(ctx context.Context, dst string, src *os.File, length int64) error {
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	copied := length / CLONE_BLOCKSIZE * CLONE_BLOCKSIZE
+	if copied == 0 || cloneRange(f, src, copied) != nil {
+		copied = 0
+	}
+	r := &ctxReader{ReadSeeker: io.NewSectionReader(src, copied, length-copied), ctx: ctx}
+	n, err := io.Copy(io.NewOffsetWriter(f, copied), r)
+	if err != nil {
+		return err
+	}
+	if copied+n != length {
+		// src was cut short while copying
+		return io.ErrUnexpectedEOF
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return f.Close()
+}
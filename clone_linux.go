@@ -0,0 +1,31 @@
+//go:build linux
+
+package gian
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// FICLONERANGE of linux/fs.h
+const ficloneRange = 0x4020940d
+
+type fileCloneRange struct {
+	srcFd      int64
+	srcOffset  uint64
+	srcLength  uint64
+	destOffset uint64
+}
+
+// cloneRange makes the first length bytes of dst share the blocks of src
+// on filesystems with copy on write, like btrfs or xfs. length must be a
+// multiple of the block size.
+func cloneRange(dst, src *os.File, length int64) error {
+	arg := fileCloneRange{srcFd: int64(src.Fd()), srcLength: uint64(length)}
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficloneRange, uintptr(unsafe.Pointer(&arg)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
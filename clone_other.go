@@ -0,0 +1,12 @@
+//go:build !linux
+
+package gian
+
+import (
+	"errors"
+	"os"
+)
+
+func cloneRange(dst, src *os.File, length int64) error {
+	return errors.ErrUnsupported
+}
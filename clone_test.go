@@ -0,0 +1,101 @@
+package gian
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_snapshot_*")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "a.dat")
+
+	gian := New(filename)
+	defer gian.Close()
+	big := make([]byte, 3*CLONE_BLOCKSIZE+100)
+	gian.Append(big)
+	gian.Write([]byte("buffered"))
+
+	// writes go on during the copy
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for range 100 {
+			gian.Append([]byte("during"))
+		}
+	}()
+	index, err := gian.Snapshot(filepath.Join(dir, "clone", "b.dat"))
+	wg.Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index < 2 {
+		t.Errorf("MUST HOLD THE BUFFERED WRITE, got %d", index)
+	}
+
+	clone := filepath.Join(dir, "clone", "b.dat")
+	if got, err := ReadFromStart(clone, nil); err != nil || got != int(index) {
+		t.Errorf("SHOULDEQ %d, got %d %v", index, got, err)
+	}
+	if checkSumFile(clone) != checkSumFile(clone+".bak") {
+		t.Errorf("BOTH FILES MUST MATCH")
+	}
+	c := New(clone)
+	defer c.Close()
+	if got, err := c.Append([]byte("clone only")); err != nil || got != index+1 {
+		t.Errorf("SHOULDEQ %d, got %d %v", index+1, got, err)
+	}
+	rc, _ := c.OpenRecord(1)
+	out, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil || !bytes.Equal(out, big) {
+		t.Errorf("SHOULD KEEP THE DATA, got %d bytes %v", len(out), err)
+	}
+	if last, _ := gian.CommittedIndex(); last != 102 {
+		t.Errorf("SHOULDEQ 102, got %d", last)
+	}
+
+	if _, err := gian.Snapshot(clone); !os.IsExist(err) {
+		t.Errorf("SHOULD EXIST, got %v", err)
+	}
+}
+
+func TestSnapshotDuringFix(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_snapshot_*")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "a.dat")
+
+	gian := New(filename)
+	defer gian.Close()
+	for range 2000 {
+		gian.Append([]byte("some record data"))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			gian.Fix()
+		}
+	}()
+	for i := range 20 {
+		clone := filepath.Join(dir, "clone", fmt.Sprintf("%d.dat", i))
+		index, err := gian.Snapshot(clone)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := ReadFromStart(clone, nil); err != nil || got != int(index) {
+			t.Errorf("SHOULDEQ %d, got %d %v", index, got, err)
+		}
+		if checkSumFile(clone) != checkSumFile(clone+".bak") {
+			t.Errorf("BOTH FILES MUST MATCH")
+		}
+	}
+	<-done
+}
@@ -0,0 +1,34 @@
+// Command gianmerge merges gian logs into a new one.
+//
+//	gianmerge [-by-time] [-keep-source] -o merged.dat a.dat b.dat ...
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/thanhpk/gian"
+)
+
+func main() {
+	out := flag.String("o", "", "path of the merged log, must not exist")
+	byTime := flag.Bool("by-time", false, "interleave the records by commit time instead of copying the logs one after the other")
+	keepSource := flag.Bool("keep-source", false, "record the source log and index of every record in its attributes")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [-by-time] [-keep-source] -o merged source...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *out == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	count, err := gian.Merge(*out, flag.Args(), gian.MergeOptions{ByTime: *byTime, KeepSource: *keepSource})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "gianmerge:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("merged %d records into %s\n", count, *out)
+}
@@ -0,0 +1,338 @@
+package gian
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrOffsetMismatch is returned when the record a consumer acknowledged is
+// no longer in the log, it was truncated or replaced
+var ErrOffsetMismatch = errors.New("consumer offset does not match the log")
+
+// Consumer reads the records of a Gian oldest first, like a queue, and
+// remembers how far it got under its name. Its offset, the last frame of
+// the last acknowledged record and the checksum chained into it, is kept
+// in two files next to the log, so a consumer opened again after a crash
+// resumes right after the last record acknowledged, once the offset was
+// checked against the log.
+type Consumer struct {
+	mu   sync.Mutex
+	g    *Gian
+	name string
+
+	acked     int    // last frame of the last record acknowledged
+	checksum  uint32 // checksum of that frame
+	trunc     uint64 // truncations of the log when acked was checked
+	next      int    // first frame of the record Next returns
+	r         *recordReader
+	delivered []delivery // returned by Next and not acknowledged yet
+}
+
+// delivery is where a record returned by Next ends
+type delivery struct {
+	index    int
+	last     int
+	checksum uint32
+}
+
+// NewConsumer opens the consumer called name, a new one starts at the
+// oldest record
+func (g *Gian) NewConsumer(name string) (*Consumer, error) {
+	return g.NewConsumerContext(context.Background(), name)
+}
+
+func (g *Gian) NewConsumerContext(ctx context.Context, name string) (*Consumer, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return nil, errors.New("invalid consumer name")
+	}
+	c := &Consumer{g: g, name: name}
+	g.fmu.RLock()
+	acked, checksum, err := c.loadOffset()
+	g.fmu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+	c.acked, c.checksum = acked, checksum
+	if err := c.checkOffset(ctx); err != nil {
+		return nil, err
+	}
+	c.next = acked + 1
+	return c, nil
+}
+
+// checkOffset makes sure the record acknowledged last is still in the log
+func (c *Consumer) checkOffset(ctx context.Context) error {
+	trunc := c.g.truncations.Load()
+	if c.acked > 0 {
+		sum, err := c.g.recordChecksum(ctx, c.acked)
+		if err == ErrNoRecord || err == nil && sum != c.checksum {
+			return ErrOffsetMismatch
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c.trunc = trunc
+	return nil
+}
+
+// offsetFile is where the offset is kept, next to the log, the caller
+// holds fmu so the log cannot move meanwhile
+func (c *Consumer) offsetFile() string {
+	return c.g.filename + ".consumer." + c.name
+}
+
+// loadOffset reads both copies of the offset and returns the newest one
+// that is whole, a copy left behind by a crash is written again
+func (c *Consumer) loadOffset() (int, uint32, error) {
+	found, valid := false, false
+	acked, checksum := 0, uint32(0)
+	stale := false
+	for _, filename := range []string{c.offsetFile(), c.offsetFile() + ".bak"} {
+		b, err := os.ReadFile(filename)
+		if errors.Is(err, os.ErrNotExist) {
+			stale = stale || found
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		found = true
+		index, sum, ok := decodeOffset(b)
+		if !ok {
+			c.g.corrupted(filename, 0, CorruptChecksum)
+			stale = true
+			continue
+		}
+		if valid && index != acked {
+			stale = true
+		}
+		if !valid || index > acked {
+			acked, checksum = index, sum
+		}
+		valid = true
+	}
+	if found && !valid {
+		return 0, 0, &CorruptionError{Kind: CorruptChecksum, Offset: 0}
+	}
+	if stale {
+		if err := c.writeOffset(acked, checksum); err != nil {
+			return 0, 0, err
+		}
+	}
+	return acked, checksum, nil
+}
+
+// writeOffset replaces both copies of the offset one after the other, a
+// crash leaves at least one whole
+func (c *Consumer) writeOffset(acked int, checksum uint32) error {
+	b := encodeOffset(acked, checksum)
+	if err := replaceFile(c.offsetFile(), b); err != nil {
+		return err
+	}
+	c.g.stats.fsync()
+	if err := replaceFile(c.offsetFile()+".bak", b); err != nil {
+		return err
+	}
+	c.g.stats.fsync()
+	return nil
+}
+
+// [ N ] [ CHECKSUM of frame N ] [ CRC of both ]
+func encodeOffset(acked int, checksum uint32) []byte {
+	b := make([]byte, 0, 8+4+4)
+	b = binary.BigEndian.AppendUint64(b, uint64(acked))
+	b = binary.BigEndian.AppendUint32(b, checksum)
+	return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+}
+
+func decodeOffset(b []byte) (int, uint32, bool) {
+	if len(b) != 8+4+4 || crc32.ChecksumIEEE(b[:12]) != binary.BigEndian.Uint32(b[12:]) {
+		return 0, 0, false
+	}
+	return int(binary.BigEndian.Uint64(b)), binary.BigEndian.Uint32(b[8:]), true
+}
+
+// recordChecksum returns the checksum of frame index, ErrNoRecord if it is
+// not the last frame of a record. Both files are fixed first if the frames
+// around it are damaged.
+func (g *Gian) recordChecksum(ctx context.Context, index int) (uint32, error) {
+	for repaired := false; ; repaired = true {
+		g.mu.Lock()
+		end, top, err := g.snapshot(ctx)
+		fixes := g.fixes
+		g.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		if index < 1 || index > top {
+			return 0, ErrNoRecord
+		}
+
+		checksum, err := g.frameChecksum(end, index)
+		var cerr *CorruptionError
+		if repaired || !errors.As(err, &cerr) {
+			return checksum, err
+		}
+		g.mu.Lock()
+		if g.fixes == fixes {
+			err = g.fix(ctx, string(cerr.Kind))
+		}
+		g.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (g *Gian) frameChecksum(end int64, index int) (uint32, error) {
+	g.fmu.RLock()
+	f, err := os.Open(g.filename)
+	g.fmu.RUnlock()
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	pos, err := frameBoundary(f, end, index+1)
+	if err != nil {
+		return 0, err
+	}
+	if pos == 0 {
+		return 0, ErrNoRecord
+	}
+	_, head, err := frameBefore(f, pos)
+	if err != nil {
+		return 0, err
+	}
+	b := [4]byte{}
+	if _, err := f.ReadAt(b[:], pos-4); err != nil {
+		return 0, err
+	}
+	found, _ := frameIndex(head[:8])
+	_, continued := frameLength(head[8:])
+	if found != index || continued {
+		return 0, ErrNoRecord
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+// Name returns the name the consumer was opened with
+func (c *Consumer) Name() string {
+	return c.name
+}
+
+// Next returns the oldest record not returned yet, io.EOF once every
+// committed record was returned, WaitCommitted waits for more. Records
+// returned are delivered again after a restart or a truncation of the log
+// until they are acknowledged. Like opening the consumer again, Next
+// returns ErrOffsetMismatch once a truncation took away the record
+// acknowledged last.
+func (c *Consumer) Next() (*Record, error) {
+	return c.NextContext(context.Background())
+}
+
+func (c *Consumer) NextContext(ctx context.Context) (*Record, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	g := c.g
+	g.mu.Lock()
+	end, top, err := g.snapshot(ctx)
+	fixes, trunc := g.fixes, g.truncations.Load()
+	g.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	for c.trunc != trunc {
+		// the records returned may be gone, start again after the last
+		// one acknowledged if it is still there
+		if c.r != nil {
+			c.r.Close()
+			c.r = nil
+		}
+		if err := c.checkOffset(ctx); err != nil {
+			return nil, err
+		}
+		c.next, c.delivered = c.acked+1, nil
+
+		g.mu.Lock()
+		end, top, err = g.snapshot(ctx)
+		fixes, trunc = g.fixes, g.truncations.Load()
+		g.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if c.next > top {
+		return nil, io.EOF
+	}
+
+	if c.r == nil {
+		r, err := g.openRecordReader(ctx, end, fixes, trunc, c.next, 0)
+		if err != nil {
+			return nil, err
+		}
+		c.r = r
+	} else if err := c.r.nextRecord(ctx, end, fixes); err != nil {
+		c.r.Close()
+		c.r = nil
+		return nil, err
+	}
+
+	data, err := io.ReadAll(c.r)
+	if err != nil {
+		// the next call opens the record again
+		c.r.Close()
+		c.r = nil
+		return nil, err
+	}
+	rec := newRecord(uint64(c.next), c.r.start, c.r.header, data)
+	c.delivered = append(c.delivered, delivery{index: c.next, last: c.r.index - 1, checksum: binary.BigEndian.Uint32(c.r.prev[:])})
+	c.next = c.r.index
+	return rec, nil
+}
+
+// Ack durably acknowledges the record at index, returned by Next, and every
+// record returned before it
+func (c *Consumer) Ack(index uint64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	i := slices.IndexFunc(c.delivered, func(d delivery) bool { return d.index == int(index) })
+	if i < 0 {
+		if index > 0 && int(index) <= c.acked {
+			return nil
+		}
+		return errors.New("record was not returned by Next")
+	}
+	d := c.delivered[i]
+	c.g.fmu.RLock()
+	err := c.writeOffset(d.last, d.checksum)
+	c.g.fmu.RUnlock()
+	if err != nil {
+		return err
+	}
+	c.acked, c.checksum = d.last, d.checksum
+	c.delivered = c.delivered[i+1:]
+	return nil
+}
+
+// Close releases the file the consumer reads, the offset is already on
+// disk
+func (c *Consumer) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.r == nil {
+		return nil
+	}
+	err := c.r.Close()
+	c.r = nil
+	return err
+}
@@ -0,0 +1,132 @@
+package gian
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConsumer(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_consumer_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+	defer os.Remove(filename + ".consumer.q")
+	defer os.Remove(filename + ".consumer.q.bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	big := make([]byte, STREAM_CHUNKSIZE+10)
+	gian.Append([]byte("one"))
+	gian.AppendStream(bytes.NewReader(big))
+	gian.AppendRecord(Record{Type: "t", Data: []byte("three")})
+
+	c, err := gian.NewConsumer("q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct {
+		index uint64
+		data  []byte
+	}{{1, []byte("one")}, {2, big}, {4, []byte("three")}} {
+		rec, err := c.Next()
+		if err != nil || rec.Index != want.index || !bytes.Equal(rec.Data, want.data) {
+			t.Fatalf("SHOULDEQ %d, got %v", want.index, err)
+		}
+	}
+	if err := c.Ack(2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Next(); err != io.EOF {
+		t.Errorf("SHOULD BE EOF, got %v", err)
+	}
+	gian.Append([]byte("four"))
+	if rec, err := c.Next(); err != nil || string(rec.Data) != "four" {
+		t.Errorf("SHOULDEQ four, got %v", err)
+	}
+	c.Close()
+
+	// not acknowledged, delivered again
+	c, err = gian.NewConsumer("q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := c.Next()
+	if err != nil || rec.Index != 4 || rec.Type != "t" || string(rec.Data) != "three" {
+		t.Fatalf("SHOULDEQ three, got %+v %v", rec, err)
+	}
+	c.Ack(4)
+	c.Close()
+
+	// a damaged copy of the offset is healed from the other one
+	os.WriteFile(filename+".consumer.q", []byte("garbage"), 0644)
+	c, err = gian.NewConsumer("q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := c.Next(); err != nil || string(rec.Data) != "four" {
+		t.Errorf("SHOULDEQ four, got %v", err)
+	}
+	c.Close()
+	main, _ := os.ReadFile(filename + ".consumer.q")
+	bak, _ := os.ReadFile(filename + ".consumer.q.bak")
+	if !bytes.Equal(main, bak) {
+		t.Errorf("MUST HEAL")
+	}
+
+	// the acknowledged record is gone
+	if err := gian.TruncateTo(3); err != nil {
+		t.Fatal(err)
+	}
+	gian.Append([]byte("other"))
+	if _, err := gian.NewConsumer("q"); err != ErrOffsetMismatch {
+		t.Errorf("SHOULD MISMATCH, got %v", err)
+	}
+}
+
+func TestConsumerTruncated(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_consumer_*")
+	defer os.RemoveAll(dir)
+	gian := New(filepath.Join(dir, "a.dat"))
+	defer gian.Close()
+	for i := range 5 {
+		gian.Append([]byte(fmt.Sprint(i + 1)))
+	}
+
+	c, _ := gian.NewConsumer("q")
+	for range 5 {
+		rec, err := c.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.Ack(rec.Index)
+	}
+	gian.TruncateTo(3)
+	for range 3 {
+		gian.Append([]byte("new"))
+	}
+	if _, err := c.Next(); err != ErrOffsetMismatch {
+		t.Errorf("SHOULD MISMATCH LIKE A RESTART, got %v", err)
+	}
+	if _, err := gian.NewConsumer("q"); err != ErrOffsetMismatch {
+		t.Errorf("SHOULD MISMATCH, got %v", err)
+	}
+
+	// records returned but not acknowledged are returned again
+	d, _ := gian.NewConsumer("r")
+	rec, _ := d.Next()
+	d.Ack(rec.Index)
+	d.Next()
+	d.Next()
+	gian.TruncateTo(2)
+	gian.Append([]byte("replaced"))
+	if rec, err := d.Next(); err != nil || rec.Index != 2 || string(rec.Data) != "2" {
+		t.Errorf("SHOULD RETURN 2 AGAIN, got %v %v", rec, err)
+	}
+	if rec, err := d.Next(); err != nil || rec.Index != 3 || string(rec.Data) != "replaced" {
+		t.Errorf("SHOULD RETURN THE NEW 3, got %v %v", rec, err)
+	}
+}
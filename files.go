@@ -0,0 +1,178 @@
+package gian
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+)
+
+// suffixes of the files kept next to a log, after its name, the temporary
+// copies a fix or truncation leaves behind when it crashes included
+var sidecars = []string{".bak", ".truncate", ".truncate.tmp", ".fix.tmp", ".bak.fix.tmp"}
+
+// prefixes of the sidecars kept one per consumer or checkpoint
+var sidecarPrefixes = []string{".consumer.", ".checkpoint."}
+
+// logFiles returns the files of the log at filename that exist, the main
+// file first
+func logFiles(filename string) ([]string, error) {
+	entries, err := os.ReadDir(filepath.Dir(filename))
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(filename)
+	files := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if name == base {
+			files = append([]string{filename}, files...)
+			continue
+		}
+		rest, ok := strings.CutPrefix(name, base)
+		if !ok {
+			continue
+		}
+		if slices.Contains(sidecars, rest) || slices.ContainsFunc(sidecarPrefixes, func(prefix string) bool {
+			return strings.HasPrefix(rest, prefix)
+		}) {
+			files = append(files, filepath.Join(filepath.Dir(filename), name))
+		}
+	}
+	return files, nil
+}
+
+// removeLog removes the log at filename and its sidecars
+func removeLog(filename string) error {
+	files, err := logFiles(filename)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// renameLog moves the log at from and its sidecars to to, the main file
+// last. Each file is renamed atomically, or copied across filesystems, a
+// crash in the middle leaves the main file at one path and the backup at
+// the other, the log heals from either.
+func renameLog(from, to string) error {
+	files, err := logFiles(from)
+	if err != nil {
+		return err
+	}
+	makeSurePath(to)
+	for i := len(files) - 1; i >= 0; i-- {
+		suffix := strings.TrimPrefix(filepath.Base(files[i]), filepath.Base(from))
+		if err := moveFile(files[i], to+suffix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// moveFile renames src to dst, or copies and syncs it then removes src
+// when they are on different filesystems
+func moveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// Move moves the log, backup and sidecars included, to newPath, which must
+// not hold a log, and goes on writing it there. Buffered writes are
+// committed first. Readers, records and consumers opened before stay
+// valid, they keep the file they have open and open the log at newPath
+// from then on. Move waits for the files being opened and the checkpoint
+// being saved.
+func (g *Gian) Move(newPath string) error {
+	g.rmu.Lock()
+	defer g.rmu.Unlock()
+	if g.reader != nil {
+		g.reader.Close()
+		g.reader = nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if filepath.Clean(newPath) == filepath.Clean(g.filename) {
+		return nil
+	}
+	if _, err := os.Stat(newPath); !errors.Is(err, os.ErrNotExist) {
+		if err == nil {
+			return os.ErrExist
+		}
+		return err
+	}
+	if err := g.forceCommit(context.Background()); err != nil {
+		return err
+	}
+	reopen := g.wfile != nil || g.wbakfile != nil
+	if g.wfile != nil {
+		g.wfile.Close()
+		g.wfile = nil
+	}
+	if g.wbakfile != nil {
+		g.wbakfile.Close()
+		g.wbakfile = nil
+	}
+
+	g.fmu.Lock()
+	err := renameLog(g.filename, newPath)
+	if err == nil {
+		g.filename = newPath
+		g.moves++
+	}
+	g.fmu.Unlock()
+	if err != nil {
+		// the next load heals the log from the files left at filename
+		g.loaded = false
+		return err
+	}
+	if reopen {
+		return g.openWriters()
+	}
+	return nil
+}
+
+// Delete closes the log and removes it, backup and sidecars included
+func (g *Gian) Delete() error {
+	err := g.Close()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if rerr := removeLog(g.filename); rerr != nil && !errors.Is(rerr, os.ErrNotExist) {
+		return rerr
+	}
+	return err
+}
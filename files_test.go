@@ -0,0 +1,208 @@
+package gian
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMove(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_move_*")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "a.dat")
+
+	gian := New(filename)
+	defer gian.Close()
+	gian.Append([]byte("one"))
+	gian.Write([]byte("two"))
+	c, _ := gian.NewConsumer("q")
+	c.Next()
+	c.Ack(1)
+
+	moved := filepath.Join(dir, "sub", "b.dat")
+	if err := gian.Move(moved); err != nil {
+		t.Fatal(err)
+	}
+	if gian.GetFileName() != moved {
+		t.Errorf("SHOULDEQ %s, got %s", moved, gian.GetFileName())
+	}
+	for _, name := range []string{filename, filename + ".bak", filename + ".consumer.q"} {
+		if _, err := os.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s MUST MOVE, got %v", name, err)
+		}
+	}
+	if index, err := gian.Append([]byte("three")); err != nil || index != 3 {
+		t.Errorf("SHOULDEQ 3, got %d %v", index, err)
+	}
+	if index, err := ReadFromStart(moved, nil); err != nil || index != 3 {
+		t.Errorf("SHOULDEQ 3, got %d %v", index, err)
+	}
+	if checkSumFile(moved) != checkSumFile(moved+".bak") {
+		t.Errorf("BOTH FILES MUST MOVE")
+	}
+	c, err := gian.NewConsumer("q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := c.Next(); err != nil || string(rec.Data) != "two" {
+		t.Errorf("SHOULD RESUME, got %v", err)
+	}
+
+	other := New(filepath.Join(dir, "c.dat"))
+	other.Append([]byte("other"))
+	other.Close()
+	if err := gian.Move(filepath.Join(dir, "c.dat")); !os.IsExist(err) {
+		t.Errorf("SHOULD EXIST, got %v", err)
+	}
+	if err := gian.Rename(filepath.Join(dir, "c.dat")); err != nil {
+		t.Fatal(err)
+	}
+	out, err := gian.ReadAll()
+	if err != nil || string(out) != "threetwoone" {
+		t.Errorf("SHOULDEQ threetwoone, got %q %v", out, err)
+	}
+
+	if err := gian.Delete(); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 || entries[0].Name() != "sub" {
+		t.Errorf("MUST DELETE EVERYTHING, got %v", entries)
+	}
+}
+
+func TestLogFiles(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_files_*")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "foo")
+
+	mine := []string{"foo", "foo.bak", "foo.truncate", "foo.consumer.q", "foo.consumer.q.bak", "foo.checkpoint.3"}
+	others := []string{"foo.bak2", "foo.bak.orig", "foo.truncated", "foobar", "foo.consumers"}
+	for _, name := range append(mine, others...) {
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+	files, err := logFiles(filename)
+	if err != nil || len(files) != len(mine) || files[0] != filename {
+		t.Errorf("SHOULDEQ %v, got %v %v", mine, files, err)
+	}
+
+	if err := removeLog(filename); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range others {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("%s MUST STAY, got %v", name, err)
+		}
+	}
+}
+
+func TestMoveWhileReading(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_move_*")
+	defer os.RemoveAll(dir)
+	paths := []string{filepath.Join(dir, "a.dat"), filepath.Join(dir, "b.dat")}
+
+	gian := New(paths[0])
+	defer gian.Close()
+	for i := range 200 {
+		gian.AppendRecord(Record{Data: []byte(fmt.Sprintf("record%d", i))})
+	}
+	c, err := gian.NewConsumer("q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	// every handle keeps working while the log moves back and forth
+	stop := make(chan struct{})
+	errs := make(chan error, 4)
+	var wg sync.WaitGroup
+	loop := func(step func() error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					errs <- nil
+					return
+				default:
+				}
+				if err := step(); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	loop(func() error {
+		if report := gian.Scrub(); !report.Healthy() || report.Repaired {
+			return fmt.Errorf("scrub %+v", report)
+		}
+		return nil
+	})
+	loop(func() error {
+		n := 0
+		for _, err := range gian.Records(context.Background(), 1) {
+			if err != nil {
+				return err
+			}
+			n++
+		}
+		if n != 200 {
+			return fmt.Errorf("read %d records", n)
+		}
+		return nil
+	})
+	consumed := 0
+	consume := func() error {
+		rec, err := c.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		consumed++
+		return c.Ack(rec.Index)
+	}
+	loop(consume)
+	loop(func() error {
+		_, err := gian.SeekTime(time.Now().Add(-time.Hour))
+		return err
+	})
+
+	for i := range 50 {
+		if err := gian.Move(paths[(i+1)%2]); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+	for range 4 {
+		if err := <-errs; err != nil {
+			t.Errorf("MUST KEEP READING, got %v", err)
+		}
+	}
+	if repairs := gian.Stats().Repairs; repairs != 0 {
+		t.Errorf("MUST NOT REPAIR, got %d", repairs)
+	}
+	for {
+		rec, err := c.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		consumed++
+		c.Ack(rec.Index)
+	}
+	if consumed != 200 {
+		t.Errorf("SHOULD CONSUME EVERY RECORD ONCE, got %d", consumed)
+	}
+}
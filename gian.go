@@ -1,14 +1,16 @@
 package gian
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
 	"hash/crc32"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/thanhpk/vdisk"
@@ -17,18 +19,47 @@ import (
 const DEFAULT_CHUNKSIZE = 4096 // 4kb
 const ONEGB = 1 * 1024 * 1024 * 1024
 
+// CONTINUED is set in both length fields of a frame whose record goes on in
+// the next frame, see AppendStream
+const CONTINUED = 1 << 31
+
+var ErrClosed = errors.New("gian is closed")
+
+// ErrConflict is returned by AppendIfLast when another record was appended
+// after the expected one
+var ErrConflict = errors.New("last index has moved on")
+
 // self healing file
 type Gian struct {
 	mu       sync.Mutex
 	stopOnce sync.Once
 	stopChan chan struct{}
 
+	// changed by Move holding both mu and fmu, a reader not holding mu
+	// holds fmu while it opens a file of the log so it cannot move away
 	filename string
+	fmu      sync.RWMutex
+	moves    int // number of Move, guarded by mu
 
 	// writing
 	lastCheckSum   uint32
 	lastWriteIndex int
 	loaded         bool
+	commitCh       chan struct{} // closed on the next commit, nil if no one waits
+
+	// idempotency keys of the latest records, nil until AppendWithKey
+	// needs them and after a load
+	keys        *keyWindow
+	dedupWindow int
+	// latest commit time in the log, unix nanoseconds, read from the
+	// files when stampLoaded is false
+	lastStamp   int64
+	stampLoaded bool
+	stampTime   bool // stamp every write, see Options.StampTime
+
+	// sparse index of commit times, guarded by tmu taken before mu
+	tmu   sync.Mutex
+	times *timeIndex
 
 	chunkSize      int
 	uncommitLength int
@@ -36,18 +67,81 @@ type Gian struct {
 
 	wfile    *os.File
 	wbakfile *os.File
+	closed   bool   // set by Close, writes fail with ErrClosed after it
+	onOpen   func() // called with mu held when the files are opened for writing
 
-	// reading
-	rfile             *vdisk.File
-	rr                *RReader
-	lastReadCheckSumB [4]byte
-	lastReadIndex     int
-	readBuffer        []byte
-
+	// reading, Read and ReadAll share one cursor guarded by rmu so they
+	// never hold mu while reading the disk
+	rmu          sync.Mutex
+	reader       *Reader // nil until the first read and after Reset
 	limitReadMbs float64
+	isolation    Isolation
+	// wraps the main file under a Reader, tests use it to pause a read
+	wrapRead    func(io.ReadSeeker) io.ReadSeeker
+	fixes       int // number of rewrites of both files
+	truncations atomic.Uint64
+
+	// scrubbing
+	scrubLimitMbs float64
+	lastScrub     ScrubReport
+
+	observer Observer
+	stats    *stats
+
+	fsync     bool
+	group     *commitQueue // nil unless group commit is on
+	groupDone chan struct{}
+}
+
+// Options configures a Gian created by NewWithOptions. The zero value is
+// the configuration used by New.
+type Options struct {
+	// read throughput in MB/s, 0 means no limit
+	LimitReadMbs float64
+	// what Read, ReadAll and NewReader see of the records not committed
+	// yet, ReadPending by default
+	Isolation Isolation
+	// number of latest records whose idempotency key AppendWithKey
+	// remembers, 0 means DEFAULT_DEDUP_WINDOW
+	DedupWindow int
+
+	// how often the background scrubber re-verifies both replicas,
+	// 0 disables the scrubber
+	ScrubInterval time.Duration
+	// scrub throughput in MB/s, 0 means no limit
+	ScrubLimitMbs float64
+
+	// receives corruption, repair and commit events, nil ignores them
+	Observer Observer
+
+	// Write and Append hand records to a single writer goroutine which
+	// commits whatever has arrived as one batch, they return once their
+	// record is on both files
+	GroupCommit bool
+	// fsync both files after each commit, once per batch with GroupCommit
+	Fsync bool
+	// stamp the commit time on the records of every write, not only on
+	// the ones of AppendRecord, so SeekTime finds them all
+	StampTime bool
 }
 
 func New(filename string) *Gian {
+	return NewWithOptions(filename, Options{})
+}
+
+func NewWithReadLimit(filename string, limitReadMbs float64) *Gian {
+	return NewWithOptions(filename, Options{LimitReadMbs: limitReadMbs})
+}
+
+func NewWithOptions(filename string, opts Options) *Gian {
+	me := newGian(filename, opts)
+	go me.autoCommit()
+	return me
+}
+
+// newGian is NewWithOptions without the goroutine committing buffered
+// writes, a Store commits for all its streams
+func newGian(filename string, opts Options) *Gian {
 	if filename == "" {
 		file, _ := os.CreateTemp("", "gian_*.dat")
 		filename = file.Name()
@@ -57,60 +151,113 @@ func New(filename string) *Gian {
 		filename:       filename,
 		chunkSize:      DEFAULT_CHUNKSIZE,
 		uncommitBuffer: make([]byte, DEFAULT_CHUNKSIZE),
-		readBuffer:     make([]byte, DEFAULT_CHUNKSIZE),
 		limitReadMbs:   100_000, //  ~ 100Gbs/s -> no limit
+		scrubLimitMbs:  100_000,
 		stopChan:       make(chan struct{}),
+		observer:       NopObserver{},
+		stats:          newStats(),
+		dedupWindow:    DEFAULT_DEDUP_WINDOW,
 	}
-	go me.autoCommit()
-	return me
-}
-
-func NewWithReadLimit(filename string, limitReadMbs float64) *Gian {
-	me := New(filename)
-	if limitReadMbs > 0 {
-		me.limitReadMbs = limitReadMbs
+	if opts.Observer != nil {
+		me.observer = opts.Observer
+	}
+	if opts.LimitReadMbs > 0 {
+		me.limitReadMbs = opts.LimitReadMbs
+	}
+	if opts.ScrubLimitMbs > 0 {
+		me.scrubLimitMbs = opts.ScrubLimitMbs
+	}
+	if opts.DedupWindow > 0 {
+		me.dedupWindow = opts.DedupWindow
+	}
+	me.isolation = opts.Isolation
+	me.fsync = opts.Fsync
+	me.stampTime = opts.StampTime
+	if opts.GroupCommit {
+		me.group = newCommitQueue()
+		me.groupDone = make(chan struct{})
+		go me.groupCommit()
+	}
+	if opts.ScrubInterval > 0 {
+		go me.scrubber(opts.ScrubInterval)
 	}
 	return me
 }
 
 func (g *Gian) GetFileName() string {
+	g.fmu.RLock()
+	defer g.fmu.RUnlock()
 	return g.filename
 }
 
+// Close commits the buffered writes and closes the files, writes fail with
+// ErrClosed after it
 func (g *Gian) Close() error {
 	g.stopOnce.Do(func() {
 		close(g.stopChan)
 	})
+	if g.group != nil {
+		// let the writer commit what is queued
+		<-g.groupDone
+	}
+
+	g.rmu.Lock()
+	if g.reader != nil {
+		g.reader.Close()
+	}
+	g.rmu.Unlock()
 
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	err := g.forceCommit()
-	if g.rfile != nil {
-		g.rfile.Close()
-	}
+	err := g.forceCommit(context.Background())
 	if g.wfile != nil {
 		g.wfile.Close()
+		g.wfile = nil
 	}
 	if g.wbakfile != nil {
 		g.wbakfile.Close()
+		g.wbakfile = nil
 	}
+	g.closed = true
 	return err
 }
 
 func (g *Gian) Write(data []byte) error {
+	return g.WriteContext(context.Background(), data)
+}
+
+// WriteContext is Write that gives up when ctx is done before data is
+// buffered or written. With group commit, a record already picked up by the
+// writer goroutine may still be committed after ctx is done.
+func (g *Gian) WriteContext(ctx context.Context, data []byte) error {
+	if g.group != nil {
+		if len(data) == 0 {
+			return nil
+		}
+		_, err := g.groupAppend(ctx, data)
+		return err
+	}
+
 	g.mu.Lock()
 	defer g.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if g.closed {
+		// nothing would commit it
+		return ErrClosed
+	}
 
 	if g.uncommitLength > 0 && len(data)+g.uncommitLength > g.chunkSize {
-		if err := g.commit(g.uncommitBuffer[:g.uncommitLength]); err != nil {
+		if err := g.commit(ctx, g.uncommitBuffer[:g.uncommitLength]); err != nil {
 			return err
 		}
 		g.uncommitLength = 0
 	}
 
 	if len(data) > g.chunkSize {
-		return g.commit(data)
+		return g.commit(ctx, data)
 	}
 	copy(g.uncommitBuffer[g.uncommitLength:g.uncommitLength+len(data)], data)
 	g.uncommitLength += len(data)
@@ -118,75 +265,247 @@ func (g *Gian) Write(data []byte) error {
 }
 
 func (g *Gian) Fix() error {
+	return g.FixContext(context.Background())
+}
+
+// FixContext is Fix that gives up when ctx is done, both files are left
+// untouched unless the rewrite has started. Both files are scanned and the
+// fixed copy is built without the lock, writers are only blocked while it
+// replaces them.
+func (g *Gian) FixContext(ctx context.Context) error {
+	g.mu.Lock()
+	if err := g.finishTruncate(); err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	filename, fixes, moves := g.filename, g.fixes, g.moves
+	mainSize, bakSize := fileSize(filename), fileSize(filename+".bak")
+	lastWriteIndex := g.lastWriteIndex
+	g.mu.Unlock()
+
+	plan := g.planFix(ctx, filename, mainSize, bakSize, lastWriteIndex)
+	defer plan.close()
+	if ctx.Err() != nil && errors.Is(plan.err, ctx.Err()) {
+		// nothing was repaired
+		return plan.err
+	}
+
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	return g.fix()
+	if g.moves != moves || plan.err == nil && (g.fixes != fixes ||
+		fileSize(g.filename) != mainSize || fileSize(g.filename+".bak") != bakSize) {
+		// frames were committed, the files rewritten or moved meanwhile,
+		// the plan would drop them
+		return g.fix(ctx, "manual")
+	}
+	return g.applyFix("manual", plan)
 }
 
-func (g *Gian) fix() error {
-	if g.wfile != nil {
-		g.wfile.Close()
-		g.wfile = nil
+// fixPlan is a fixed copy of the log waiting to replace both files
+type fixPlan struct {
+	tmp         *os.File
+	recovered   int
+	lost        int
+	corruptions []fileCorruption // reported when the plan is applied
+	err         error
+}
+
+type fileCorruption struct {
+	filename string
+	err      error
+}
+
+// dropUnfinished cuts the fixed copy before a unit cut short and lowers
+// recovered to the last frame left
+func (p *fixPlan) dropUnfinished() error {
+	end, err := p.tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
 	}
-	if g.wbakfile != nil {
-		g.wbakfile.Close()
-		g.wbakfile = nil
+	start, err := unitBoundary(p.tmp, end)
+	if err != nil || start == end {
+		return err
 	}
-	if g.rfile != nil {
-		g.rfile.Close()
-		g.rfile = nil
+	if err := p.tmp.Truncate(start); err != nil {
+		return err
+	}
+	if _, err := p.tmp.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	p.recovered = 0
+	if start > 0 {
+		_, head, err := frameBefore(p.tmp, start)
+		if err != nil {
+			return err
+		}
+		p.recovered, _ = frameIndex(head[:8])
 	}
+	return nil
+}
 
-	findex, _ := ReadFromStart(g.filename, nil)
-	bindex, _ := ReadFromStart(g.filename+".bak", nil)
+func (p *fixPlan) close() {
+	if p.tmp != nil {
+		p.tmp.Close()
+		os.Remove(p.tmp.Name())
+	}
+}
+
+// fix rebuilds both files from the longest healthy chain found in either of
+// them, reason is passed to the observer
+func (g *Gian) fix(ctx context.Context, reason string) error {
+	if err := g.finishTruncate(); err != nil {
+		return err
+	}
+	plan := g.planFix(ctx, g.filename, fileSize(g.filename), fileSize(g.filename+".bak"), g.lastWriteIndex)
+	defer plan.close()
+	return g.applyFix(reason, plan)
+}
+
+// planFix scans the first mainSize and bakSize bytes of both files of the
+// log at filename and builds the fixed copy in a temporary file, it does
+// not need the lock as long as both sizes end at a frame boundary
+func (g *Gian) planFix(ctx context.Context, filename string, mainSize, bakSize int64, lastWriteIndex int) *fixPlan {
+	plan := &fixPlan{}
+	findex, ferr := readFileFromStart(ctx, filename, mainSize, nil)
+	bindex, berr := readFileFromStart(ctx, filename+".bak", bakSize, nil)
+	if plan.err = ctx.Err(); plan.err != nil {
+		return plan
+	}
+	plan.corruptions = append(plan.corruptions,
+		fileCorruption{filename, ferr}, fileCorruption{filename + ".bak", berr})
+	if ferr == nil && berr == nil && findex != bindex {
+		shorter, size := filename, mainSize
+		if bindex < findex {
+			shorter, size = filename+".bak", bakSize
+		}
+		plan.corruptions = append(plan.corruptions,
+			fileCorruption{shorter, &CorruptionError{Kind: CorruptOutOfSync, Offset: size}})
+	}
 
 	// Even if findex == bindex, we might need to truncate junk at the end
 	// of both files to ensure Read() doesn't keep hitting it.
 
-	tmpFile, err := os.CreateTemp("", "gian_fix_*.tmp")
-	if err != nil {
-		return err
+	plan.tmp, plan.err = os.CreateTemp("", "gian_fix_*.tmp")
+	if plan.err != nil {
+		return plan
 	}
-	defer os.Remove(tmpFile.Name())
-	defer tmpFile.Close()
 
-	headIndex := findex
-	headFile := g.filename
+	headIndex, headFile, headSize := findex, filename, mainSize
 	if bindex > findex {
-		headIndex = bindex
-		headFile = g.filename + ".bak"
+		headIndex, headFile, headSize = bindex, filename+".bak", bakSize
 	}
 
 	// Copy the healthy head
-	if _, err := ReadFromStart(headFile, tmpFile); err != nil {
+	if _, err := readFileFromStart(ctx, headFile, headSize, plan.tmp); err != nil {
 		// It's okay if it hits corruption, we just want the healthy part
 	}
 
 	// Try to find a tail from either file that connects to this head
-	pass, _ := LoadBackwardToIndex(g.filename, headIndex, tmpFile)
+	tail, pass, _ := loadBackwardToIndex(ctx, filename, mainSize, headIndex, plan.tmp)
+	seen := max(findex, bindex, tail)
 	if !pass {
-		pass, _ = LoadBackwardToIndex(g.filename+".bak", headIndex, tmpFile)
+		tail, pass, _ = loadBackwardToIndex(ctx, filename+".bak", bakSize, headIndex, plan.tmp)
+		seen = max(seen, tail)
 	}
+	seen = max(seen, lastWriteIndex)
 
 	if headIndex == 0 && !pass {
-		return errors.New("cannot fix: both files corrupted from the start")
+		plan.lost = seen
+		plan.err = errors.New("cannot fix: both files corrupted from the start")
+		return plan
+	}
+	plan.recovered = headIndex
+	if pass {
+		plan.recovered = max(headIndex, tail)
 	}
 
-	if err := tmpFile.Sync(); err != nil {
-		return err
+	// the tail may end inside a unit whose last frame is lost in both files
+	if plan.recovered > headIndex {
+		if plan.err = plan.dropUnfinished(); plan.err != nil {
+			return plan
+		}
 	}
+	plan.lost = max(0, seen-plan.recovered)
 
-	// Overwrite both files with the fixed content
-	if err := CopyFile(g.filename, tmpFile.Name()); err != nil {
+	if plan.err = ctx.Err(); plan.err != nil {
+		return plan
+	}
+	if plan.err = plan.tmp.Sync(); plan.err == nil {
+		g.stats.fsync()
+	}
+	return plan
+}
+
+// applyFix overwrites both files with the fixed copy, it must hold the lock
+func (g *Gian) applyFix(reason string, plan *fixPlan) (err error) {
+	g.observer.OnRepairStarted(reason)
+	g.stats.repair()
+	for _, c := range plan.corruptions {
+		g.reportCorruption(c.filename, c.err)
+	}
+	defer func() {
+		g.observer.OnRepairFinished(plan.recovered, plan.lost, err)
+	}()
+	if plan.err != nil {
+		return plan.err
+	}
+
+	if g.wfile != nil {
+		g.wfile.Close()
+		g.wfile = nil
+	}
+	if g.wbakfile != nil {
+		g.wbakfile.Close()
+		g.wbakfile = nil
+	}
+
+	// Replace both files with the fixed content
+	g.fixes++
+	if err := replaceWithCopy(g.filename, plan.tmp.Name()); err != nil {
 		return err
 	}
-	if err := CopyFile(g.filename+".bak", tmpFile.Name()); err != nil {
+	g.stats.fsync()
+	if err := replaceWithCopy(g.filename+".bak", plan.tmp.Name()); err != nil {
 		return err
 	}
+	g.stats.fsync()
 
+	// the tail may have changed, reload lastWriteIndex and lastCheckSum
+	// before the next commit
+	g.loaded = false
+	g.lastWriteIndex = plan.recovered
 	return nil
 }
 
+func (g *Gian) reportCorruption(filename string, err error) {
+	if err == nil {
+		return
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		g.corrupted(filename, 0, CorruptMissing)
+		return
+	}
+	var cerr *CorruptionError
+	if errors.As(err, &cerr) {
+		g.corrupted(filename, cerr.Offset, cerr.Kind)
+	}
+}
+
+func (g *Gian) corrupted(filename string, offset int64, kind CorruptionKind) {
+	g.stats.corruption(kind)
+	g.observer.OnCorruptionDetected(filename, offset, kind)
+}
+
+// commitPending commits the buffered writes, if any
+func (g *Gian) commitPending() {
+	g.stats.autoCommit()
+	g.mu.Lock()
+	if g.uncommitLength > 0 {
+		g.forceCommit(context.Background())
+	}
+	g.mu.Unlock()
+}
+
 func (g *Gian) autoCommit() {
 	ticker := time.NewTicker(30 * time.Second)
 	defer ticker.Stop()
@@ -194,20 +513,19 @@ func (g *Gian) autoCommit() {
 	for {
 		select {
 		case <-ticker.C:
-			g.mu.Lock()
-			if g.uncommitLength > 0 {
-				g.forceCommit()
-			}
-			g.mu.Unlock()
+			g.commitPending()
 		case <-g.stopChan:
 			return
 		}
 	}
 }
 
-func mustInsync(f1, f2 string) error {
-	i1, err1 := ReadFromStart(f1, nil)
-	i2, err2 := ReadFromStart(f2, nil)
+func mustInsync(ctx context.Context, f1, f2 string) error {
+	i1, err1 := readFileFromStart(ctx, f1, -1, nil)
+	i2, err2 := readFileFromStart(ctx, f2, -1, nil)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
 	if err1 != nil {
 		if i2 == 0 {
@@ -237,72 +555,178 @@ func makeSurePath(filename string) {
 	}
 }
 
-func (g *Gian) commit(data []byte) error {
-	if !g.loaded {
-		makeSurePath(g.filename)
+func (g *Gian) commit(ctx context.Context, data []byte) error {
+	if err := g.load(ctx); err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	return g.writeFrame(data)
+}
 
-		if err := mustInsync(g.filename, g.filename+".bak"); err != nil {
-			if err := g.fix(); err != nil {
-				return err
-			}
+// load reads lastWriteIndex and lastCheckSum from the tail of the file,
+// both files are fixed first if they are out of sync
+func (g *Gian) load(ctx context.Context) error {
+	if g.loaded {
+		return nil
+	}
+	makeSurePath(g.filename)
+	if err := g.finishTruncate(); err != nil {
+		return err
+	}
+
+	if err := mustInsync(ctx, g.filename, g.filename+".bak"); err != nil {
+		if ctx.Err() != nil {
+			return err
 		}
+		if err := g.fix(ctx, err.Error()); err != nil {
+			return err
+		}
+	}
 
-		file, err := os.OpenFile(g.filename, os.O_RDONLY, 0644)
-		if err == nil {
-			defer file.Close()
-			b4 := [4]byte{}
-			rr, err := NewRReaderSize(file, 1024)
-			if err != nil {
+	// a unit cut short was never acknowledged, a file may hold one the
+	// other does not
+	if err := dropUnfinished(g.filename); err != nil {
+		return err
+	}
+	if err := dropUnfinished(g.filename + ".bak"); err != nil {
+		return err
+	}
+
+	g.lastCheckSum = 0
+	g.lastWriteIndex = 0
+	file, err := os.OpenFile(g.filename, os.O_RDONLY, 0644)
+	if err == nil {
+		defer file.Close()
+		b4 := [4]byte{}
+		rr, err := NewRReaderSize(file, 1024)
+		if err != nil {
+			return err
+		}
+		n, err := rr.Read(b4[:])
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// not empty file
+		if n != 0 {
+			checksum := binary.BigEndian.Uint32(b4[:])
+			g.lastCheckSum = checksum
+
+			if _, err := rr.Read(b4[:]); err != nil {
 				return err
 			}
-			n, err := rr.Read(b4[:])
-			if err != nil && err != io.EOF {
+			l, _ := frameLength(b4[:])
+			if l > ONEGB { // 1GB {
+				return errors.New("wrong length, very broken")
+			}
+			b := make([]byte, l)
+			if _, err := rr.Read(b); err != nil {
 				return err
 			}
-			// not empty file
-			if n != 0 {
-				checksum := binary.BigEndian.Uint32(b4[:])
-				g.lastCheckSum = checksum
-				g.lastWriteIndex = 0
-
-				if _, err := rr.Read(b4[:]); err != nil {
-					return err
-				}
-				l := binary.BigEndian.Uint32(b4[:])
-				if l > ONEGB { // 1GB {
-					return errors.New("wrong length, very broken")
-				}
-				b := make([]byte, l)
-				if _, err := rr.Read(b); err != nil {
-					return err
-				}
-				if _, err := rr.Read(b4[:]); err != nil {
-					return err
-				}
-				indexb := [8]byte{}
-				if _, err := rr.Read(indexb[:]); err != nil {
-					return err
-				}
-				index := int(binary.BigEndian.Uint64(indexb[:]))
-				g.lastWriteIndex = index
+			if _, err := rr.Read(b4[:]); err != nil {
+				return err
 			}
-		} else {
-			if !errors.Is(err, os.ErrNotExist) {
+			indexb := [8]byte{}
+			if _, err := rr.Read(indexb[:]); err != nil {
 				return err
 			}
+			index, _ := frameIndex(indexb[:])
+			g.lastWriteIndex = index
+		}
+	} else {
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
 		}
-		g.loaded = true
 	}
-	if len(data) == 0 {
-		return nil
+	g.keys = nil
+	g.stampLoaded = false
+	g.loaded = true
+	return nil
+}
+
+// writeFrame appends data as the next frame to both files
+func (g *Gian) writeFrame(data []byte) error {
+	frame, flags, err := g.stampFrame(data)
+	if err != nil {
+		return err
+	}
+	return g.writeFrameFlags(frame, flags, len(data))
+}
+
+// writeFrameFlags is writeFrame with flags set in the index field, bytes is
+// the size of the payload reported to the observer
+func (g *Gian) writeFrameFlags(data []byte, flags uint64, bytes int) error {
+	if err := g.openWriters(); err != nil {
+		return err
+	}
+
+	// Aggregated write for better performance
+	buf := make([]byte, 0, 8+4+len(data)+4+4)
+	buf, checksum := encodeFrame(buf, g.lastCheckSum, uint64(g.lastWriteIndex+1)|flags, data, uint32(len(data)))
+
+	start := time.Now()
+	if _, err := g.wfile.Write(buf); err != nil {
+		return err
 	}
+	if _, err := g.wbakfile.Write(buf); err != nil {
+		return err
+	}
+	if g.fsync {
+		if err := g.wfile.Sync(); err != nil {
+			return err
+		}
+		g.stats.fsync()
+		if err := g.wbakfile.Sync(); err != nil {
+			return err
+		}
+		g.stats.fsync()
+	}
+	g.stats.commit(time.Since(start))
+
+	g.committed(g.lastWriteIndex+1, checksum, bytes)
+	return nil
+}
 
+// committed moves the write position past index, the last frame of a
+// record that is on both files
+func (g *Gian) committed(index int, checksum uint32, bytes int) {
+	g.lastWriteIndex = index
+	g.lastCheckSum = checksum
+	g.observer.OnCommit(g.lastWriteIndex, bytes)
+	if g.commitCh != nil {
+		close(g.commitCh)
+		g.commitCh = nil
+	}
+}
+
+// closeWriters commits the buffered writes and closes both files, the
+// next write opens them again, it must hold the lock
+func (g *Gian) closeWriters() error {
+	err := g.forceCommit(context.Background())
+	if g.wfile != nil {
+		g.wfile.Close()
+		g.wfile = nil
+	}
+	if g.wbakfile != nil {
+		g.wbakfile.Close()
+		g.wbakfile = nil
+	}
+	return err
+}
+
+func (g *Gian) openWriters() error {
+	if g.closed {
+		return ErrClosed
+	}
+	opened := false
 	if g.wfile == nil {
 		file, err := os.OpenFile(g.filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
 		if err != nil {
 			return err
 		}
 		g.wfile = file
+		opened = true
 	}
 
 	if g.wbakfile == nil {
@@ -311,16 +735,31 @@ func (g *Gian) commit(data []byte) error {
 			return err
 		}
 		g.wbakfile = bakfile
+		opened = true
 	}
+	if opened && g.onOpen != nil {
+		g.onOpen()
+	}
+	return nil
+}
+
+// appendFrame encodes data as the frame at index chained to the checksum
+// of the previous frame, returns the extended buf and the frame checksum
+func appendFrame(buf []byte, lastCheckSum uint32, index int, data []byte) ([]byte, uint32) {
+	return encodeFrame(buf, lastCheckSum, uint64(index), data, uint32(len(data)))
+}
 
+// encodeFrame is appendFrame with the index and length fields given, which
+// may carry BATCHED and CONTINUED
+func encodeFrame(buf []byte, lastCheckSum uint32, index uint64, data []byte, length uint32) ([]byte, uint32) {
 	lastchecksumb := [4]byte{}
-	binary.BigEndian.PutUint32(lastchecksumb[:], g.lastCheckSum)
+	binary.BigEndian.PutUint32(lastchecksumb[:], lastCheckSum)
 
 	indexB := [8]byte{}
-	binary.BigEndian.PutUint64(indexB[:], uint64(g.lastWriteIndex+1))
+	binary.BigEndian.PutUint64(indexB[:], index)
 
 	lengthB := [4]byte{}
-	binary.BigEndian.PutUint32(lengthB[:], uint32(len(data)))
+	binary.BigEndian.PutUint32(lengthB[:], length)
 
 	crc := crc32.NewIEEE()
 	crc.Write(lastchecksumb[:])
@@ -332,169 +771,315 @@ func (g *Gian) commit(data []byte) error {
 	checksumB := [4]byte{}
 	binary.BigEndian.PutUint32(checksumB[:], checksum)
 
-	// Aggregated write for better performance
-	buf := make([]byte, 0, 8+4+len(data)+4+4)
+	// [ N ] [ Length ] [ --- data ---- ] [ Length ] [ CHECKSUM ]
 	buf = append(buf, indexB[:]...)
 	buf = append(buf, lengthB[:]...)
 	buf = append(buf, data...)
 	buf = append(buf, lengthB[:]...)
 	buf = append(buf, checksumB[:]...)
+	return buf, checksum
+}
 
-	// [ N ] [ Length ] [ --- data ---- ] [ Length ] [ CHECKSUM ]
-	if _, err := g.wfile.Write(buf); err != nil {
-		return err
+// Append commits data as a frame of its own and returns the index assigned
+// to it. Data buffered by Write is committed first.
+func (g *Gian) Append(data []byte) (uint64, error) {
+	if g.group != nil {
+		return g.groupAppend(context.Background(), data)
 	}
-	if _, err := g.wbakfile.Write(buf); err != nil {
-		return err
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.forceCommit(context.Background()); err != nil {
+		return 0, err
+	}
+	if err := g.load(context.Background()); err != nil {
+		return 0, err
 	}
+	if err := g.writeFrame(data); err != nil {
+		return 0, err
+	}
+	return uint64(g.lastWriteIndex), nil
+}
 
-	g.lastWriteIndex++
-	g.lastCheckSum = checksum
-	return nil
+// AppendIfLast is Append that only commits data when expectedIndex is still
+// the index of the last record, otherwise it returns ErrConflict and
+// writes nothing. Data buffered by Write is committed first and counts as
+// a record.
+func (g *Gian) AppendIfLast(expectedIndex uint64, data []byte) (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.forceCommit(context.Background()); err != nil {
+		return 0, err
+	}
+	if err := g.load(context.Background()); err != nil {
+		return 0, err
+	}
+	if uint64(g.lastWriteIndex) != expectedIndex {
+		return 0, ErrConflict
+	}
+	if err := g.writeFrame(data); err != nil {
+		return 0, err
+	}
+	return uint64(g.lastWriteIndex), nil
+}
+
+// CommittedIndex returns the index of the last frame written to both files
+func (g *Gian) CommittedIndex() (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.load(context.Background()); err != nil {
+		return 0, err
+	}
+	return uint64(g.lastWriteIndex), nil
+}
+
+// WaitCommitted blocks until the frame at index is written to both files,
+// ctx is done or the Gian is closed
+func (g *Gian) WaitCommitted(ctx context.Context, index uint64) error {
+	for {
+		g.mu.Lock()
+		if err := g.load(ctx); err != nil {
+			g.mu.Unlock()
+			return err
+		}
+		if uint64(g.lastWriteIndex) >= index {
+			g.mu.Unlock()
+			return nil
+		}
+		if g.commitCh == nil {
+			g.commitCh = make(chan struct{})
+		}
+		ch := g.commitCh
+		g.mu.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-g.stopChan:
+			return ErrClosed
+		}
+	}
 }
 
 func (g *Gian) ForceCommit() error {
+	return g.ForceCommitContext(context.Background())
+}
+
+// ForceCommitContext is ForceCommit that gives up when ctx is done before
+// the buffered data is written
+func (g *Gian) ForceCommitContext(ctx context.Context) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	return g.forceCommit()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return g.forceCommit(ctx)
 }
 
-func (g *Gian) forceCommit() error {
+func (g *Gian) forceCommit(ctx context.Context) error {
 	if g.uncommitLength == 0 {
 		return nil // no op
 	}
-	if err := g.commit(g.uncommitBuffer[:g.uncommitLength]); err != nil {
+	if err := g.commit(ctx, g.uncommitBuffer[:g.uncommitLength]); err != nil {
 		return err
 	}
 	g.uncommitLength = 0
 	return nil
 }
 
-func (g *Gian) openFile() error {
-	makeSurePath(g.filename)
-	f, err := vdisk.NewLimiter(g.limitReadMbs).OpenFile(g.filename, os.O_RDONLY|os.O_CREATE, 0644)
-	if err != nil {
-		return err
-	}
-
-	rr, err := NewRReaderSize(f, g.chunkSize)
-	if err != nil {
-		f.Close()
-		return err
-	}
-	g.rfile = f
-	g.rr = rr
-	return nil
+// ctxReader stops between two chunks once ctx is done, so a read throttled
+// by the limiter can be cancelled
+type ctxReader struct {
+	io.ReadSeeker
+	ctx context.Context
 }
 
-func (g *Gian) fixThenRead(reason string) ([]byte, error) {
-	if err := g.fix(); err != nil {
-		return nil, err
-	}
-	if g.rfile != nil {
-		g.rfile.Close()
-		g.rfile = nil
-	}
-	if err := g.readToIndex(g.lastReadIndex); err != nil {
-		return nil, err
+func (r *ctxReader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if err := r.ctx.Err(); err != nil {
+			return n, err
+		}
+		m, err := r.ReadSeeker.Read(p[n:min(len(p), n+vdisk.MaxChunkSize)])
+		n += m
+		if err != nil {
+			return n, err
+		}
 	}
-	return g.read()
+	return n, nil
 }
 
 func ReadFromStart(filename string, writer io.Writer) (int, error) {
+	return readFileFromStart(context.Background(), filename, -1, writer)
+}
+
+// readFileFromStart verifies the first size bytes of filename, the whole
+// file if size is negative
+func readFileFromStart(ctx context.Context, filename string, size int64, writer io.Writer) (int, error) {
 	file, err := os.OpenFile(filename, os.O_RDONLY, 0644)
 	if err != nil {
 		return 0, err
 	}
 	defer file.Close()
-	lastIndex := 0
+	if size < 0 {
+		return readFromStart(ctx, file, writer)
+	}
+	return readFromStart(ctx, io.LimitReader(file, size), writer)
+}
+
+// readFromStart verifies frames from the beginning of r, returns the index
+// of the last healthy frame that ends a unit. Frames of a unit cut short
+// are not left in writer: they are held back in memory, or written and cut
+// again when writer is a file. It stops between two frames when ctx is
+// done.
+func readFromStart(ctx context.Context, r io.Reader, writer io.Writer) (int, error) {
+	lastIndex := 0     // last healthy frame
+	complete := 0      // last healthy frame ending a unit
+	offset := int64(0) // where the current frame starts
 	crc := crc32.NewIEEE()
 	checksumb := [4]byte{}
 	lastChecksumB := [4]byte{}
 	indexb := [8]byte{}
 	lenb := [4]byte{}
 	data := make([]byte, 4096)
+
+	file, direct := writer.(*os.File)
+	unit := []byte{}    // frames of the open unit held back
+	unitPos := int64(0) // where the open unit starts in file
+	done := func(err error) (int, error) {
+		if direct && lastIndex != complete {
+			if terr := file.Truncate(unitPos); terr != nil && err == nil {
+				err = terr
+			}
+			file.Seek(unitPos, io.SeekStart)
+		}
+		return complete, err
+	}
+
 	for {
+		if err := ctx.Err(); err != nil {
+			return done(err)
+		}
 		crc.Reset()
-		_, err := file.Read(indexb[:])
+		_, err := io.ReadFull(r, indexb[:])
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return lastIndex, err
+			return done(truncated(err, offset))
 		}
 		crc.Write(lastChecksumB[:])
 		crc.Write(indexb[:])
-		index := int(binary.BigEndian.Uint64(indexb[:]))
+		index, flags := frameIndex(indexb[:])
 
 		if index != lastIndex+1 {
-			return lastIndex, errors.New("wrong index")
+			return done(&CorruptionError{Kind: CorruptWrongIndex, Offset: offset})
 		}
-		if _, err := file.Read(lenb[:]); err != nil {
-			return lastIndex, err
+		if _, err := io.ReadFull(r, lenb[:]); err != nil {
+			return done(truncated(err, offset))
 		}
 		crc.Write(lenb[:])
 
-		l := binary.BigEndian.Uint32(lenb[:])
+		l, continued := frameLength(lenb[:])
 		if l > ONEGB { // 1GB {
-			return lastIndex, errors.New("wrong length 3")
+			return done(&CorruptionError{Kind: CorruptWrongLength, Offset: offset})
 		}
+		rawl := binary.BigEndian.Uint32(lenb[:])
 
 		if int(l) > len(data) {
 			data = make([]byte, int(l))
 		}
-		n, err := file.Read(data[:l])
-		if err != nil {
-			return lastIndex, err
-		}
-		if n != int(l) {
-			return lastIndex, io.ErrUnexpectedEOF
+		if _, err := io.ReadFull(r, data[:l]); err != nil {
+			return done(truncated(err, offset))
 		}
 
 		crc.Write(data[:l])
 		crc.Write(lenb[:])
-		if _, err := file.Read(lenb[:]); err != nil {
-			return lastIndex, err
+		if _, err := io.ReadFull(r, lenb[:]); err != nil {
+			return done(truncated(err, offset))
 		}
-		l2 := binary.BigEndian.Uint32(lenb[:])
-		if l2 != l {
-			return lastIndex, errors.New("wrong len")
+		if binary.BigEndian.Uint32(lenb[:]) != rawl {
+			return done(&CorruptionError{Kind: CorruptWrongLength, Offset: offset})
 		}
 
-		if _, err := file.Read(checksumb[:]); err != nil {
-			return lastIndex, err
+		if _, err := io.ReadFull(r, checksumb[:]); err != nil {
+			return done(truncated(err, offset))
 		}
 
 		checksum := binary.BigEndian.Uint32(checksumb[:])
 		if checksum != crc.Sum32() {
-			return lastIndex, errors.New("wrong check sum")
+			return done(&CorruptionError{Kind: CorruptChecksum, Offset: offset})
 		}
-		lastIndex = index
+
+		open := flags&BATCHED != 0 || continued
 		if writer != nil {
-			writer.Write(indexb[:])
-			writer.Write(lenb[:])
-			writer.Write(data[:l])
-			writer.Write(lenb[:])
-			writer.Write(checksumb[:])
+			if direct && open && lastIndex == complete {
+				if unitPos, err = file.Seek(0, io.SeekCurrent); err != nil {
+					return done(err)
+				}
+			}
+			if direct || !open && len(unit) == 0 {
+				writer.Write(indexb[:])
+				writer.Write(lenb[:])
+				writer.Write(data[:l])
+				writer.Write(lenb[:])
+				writer.Write(checksumb[:])
+			} else {
+				unit = append(unit, indexb[:]...)
+				unit = append(unit, lenb[:]...)
+				unit = append(unit, data[:l]...)
+				unit = append(unit, lenb[:]...)
+				unit = append(unit, checksumb[:]...)
+				if !open {
+					writer.Write(unit)
+					unit = unit[:0]
+				}
+			}
+		}
+		lastIndex = index
+		if !open {
+			complete = index
 		}
 		copy(lastChecksumB[:], checksumb[:])
+		offset += 8 + 4 + int64(l) + 4 + 4
 	}
 
-	return lastIndex, nil
+	return done(nil)
+}
+
+// truncated turns a short read into a CorruptionError
+func truncated(err error, offset int64) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &CorruptionError{Kind: CorruptTruncated, Offset: offset}
+	}
+	return err
 }
 
 // the return data do not include headIndex
 // (headIndex...end]
 func LoadBackwardToIndex(filename string, headIndex int, writer io.Writer) (bool, error) {
+	_, pass, err := loadBackwardToIndex(context.Background(), filename, -1, headIndex, writer)
+	return pass, err
+}
+
+// loadBackwardToIndex is LoadBackwardToIndex that also returns the index of
+// the newest healthy frame in the file, 0 if there is none. It starts at
+// end, the end of the file if end is negative.
+func loadBackwardToIndex(ctx context.Context, filename string, end int64, headIndex int, writer io.Writer) (tail int, pass bool, err error) {
 	file, err := os.OpenFile(filename, os.O_RDONLY, 0644)
 	if err != nil {
-		return false, err
+		return 0, false, err
 	}
 	defer file.Close()
-	rr, err := NewRReaderSize(file, 1024)
-	if err != nil {
-		return false, err
+	if end < 0 {
+		if end, err = file.Seek(0, io.SeekEnd); err != nil {
+			return 0, false, err
+		}
 	}
+	rr := NewRReaderAt(file, 1024, end)
 
 	readBuffer := []byte{}
 	checksumb := [4]byte{}
@@ -507,19 +1092,23 @@ func LoadBackwardToIndex(filename string, headIndex int, writer io.Writer) (bool
 
 	_, err = rr.Read(checksumb[:])
 	if err == io.EOF && headIndex == 0 {
-		return true, nil
+		return 0, true, nil
 	}
 	if err != nil && err != io.EOF {
-		return false, err
+		return 0, false, err
 	}
 	for {
+		if err := ctx.Err(); err != nil {
+			return tail, false, err
+		}
 		if _, err := rr.Read(lenb[:]); err != nil {
 			break
 		}
-		l := binary.BigEndian.Uint32(lenb[:])
+		l, _ := frameLength(lenb[:])
 		if l > ONEGB { // 1GB {
 			break
 		}
+		rawl := binary.BigEndian.Uint32(lenb[:])
 		if int(l) > len(readBuffer) {
 			readBuffer = make([]byte, l)
 		}
@@ -531,8 +1120,7 @@ func LoadBackwardToIndex(filename string, headIndex int, writer io.Writer) (bool
 			break
 		}
 
-		l2 := binary.BigEndian.Uint32(lenb[:])
-		if l2 != l {
+		if binary.BigEndian.Uint32(lenb[:]) != rawl {
 			break
 		}
 
@@ -540,17 +1128,20 @@ func LoadBackwardToIndex(filename string, headIndex int, writer io.Writer) (bool
 			break
 		}
 
-		index := int(binary.BigEndian.Uint64(indexb[:]))
+		index, _ := frameIndex(indexb[:])
 		data := readBuffer[0:l]
 
 		if index <= headIndex {
 			lastReadIndex = index
+			if tail == 0 {
+				tail = index
+			}
 			break
 		}
 		// do check sum
 		if index > 1 {
 			if _, err := rr.Read(prevchecksumb[:]); err != nil {
-				return false, err
+				return tail, false, err
 			}
 		} else {
 			prevchecksumb[0], prevchecksumb[1], prevchecksumb[2], prevchecksumb[3] = 0, 0, 0, 0
@@ -566,15 +1157,18 @@ func LoadBackwardToIndex(filename string, headIndex int, writer io.Writer) (bool
 
 		checksum := binary.BigEndian.Uint32(checksumb[:])
 		if checksum != crc.Sum32() {
-			return false, errors.New("checksum mismatch")
+			return tail, false, errors.New("checksum mismatch")
 		}
 
 		if lastReadIndex != 0 {
 			if index+1 != lastReadIndex {
-				return false, errors.New("index gap")
+				return tail, false, errors.New("index gap")
 			}
 		}
 		lastReadIndex = int(index)
+		if tail == 0 {
+			tail = index
+		}
 
 		ele := []byte{}
 		ele = append(ele, indexb[:]...)
@@ -593,232 +1187,90 @@ func LoadBackwardToIndex(filename string, headIndex int, writer io.Writer) (bool
 		if writer != nil {
 			for i := len(out) - 1; i >= 0; i-- {
 				if _, err := writer.Write(out[i]); err != nil {
-					return false, err
+					return tail, false, err
 				}
 			}
 		}
-		return true, nil
+		return tail, true, nil
 	}
-	return false, nil
+	return tail, false, nil
 }
 
-func (g *Gian) readToIndex(toindex int) error {
-	if toindex == 0 {
+// Rename is Move replacing the log at newname, if any
+func (g *Gian) Rename(newname string) error {
+	if filepath.Clean(newname) == filepath.Clean(g.GetFileName()) {
 		return nil
 	}
-	if g.rfile == nil {
-		if err := g.openFile(); err != nil {
-			return err
-		}
-	}
-	readBuffer := []byte{}
-	lenb := [4]byte{}
-
-	// skip fist checksum
-	if _, err := g.rr.Read(lenb[:]); err != nil {
+	if err := removeLog(newname); err != nil && !errors.Is(err, os.ErrNotExist) {
 		return err
 	}
-
-	var lastReadIndex int
-	for {
-		if _, err := g.rr.Read(lenb[:]); err != nil {
-			return err
-		}
-		l := binary.BigEndian.Uint32(lenb[:])
-		if l > ONEGB { // 1GB {
-			return errors.New("wrong length, very broken")
-		}
-
-		if int(l) > len(readBuffer) {
-			readBuffer = make([]byte, l)
-		}
-
-		if _, err := g.rr.Read(readBuffer[:l]); err != nil {
-			return err
-		}
-
-		if _, err := g.rr.Read(lenb[:]); err != nil {
-			return err
-		}
-
-		indexb := [8]byte{}
-		if _, err := g.rr.Read(indexb[:]); err != nil {
-			return err
-		}
-
-		index := int(binary.BigEndian.Uint64(indexb[:]))
-		if lastReadIndex != 0 {
-			if index+1 != lastReadIndex {
-				return errors.New("wrong index, broken file")
-			}
-		}
-
-		if index < toindex {
-			return errors.New("wrong index, VERY broken file")
-		}
-
-		// skip checksum
-		if _, err := g.rr.Read(lenb[:]); err != nil {
-			return err
-		}
-		lastReadIndex = index
-		if index == toindex {
-			return nil // ok
-		}
-	}
-}
-
-func (g *Gian) Rename(newname string) error {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	makeSurePath(newname)
-	os.Remove(newname)
-	return os.Rename(g.filename, newname)
+	return g.Move(newname)
 }
 
+// ReadAll returns every record from where Read stopped, newest first. With
+// ReadPending the records waiting for the next commit come first.
 func (g *Gian) ReadAll() ([]byte, error) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
+	g.rmu.Lock()
+	defer g.rmu.Unlock()
 	out := []byte{}
 	for {
-		data, err := g.read()
+		data, err := g.read(context.Background())
 		if err == io.EOF {
 			break
 		}
-
 		if err != nil {
 			return out, err
 		}
-
 		out = append(out, data...)
 	}
 	return out, nil
 }
 
+// Reset makes the next Read start again from the newest record
 func (g *Gian) Reset() {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	if g.rfile != nil {
-		g.rfile.Close()
-		g.rfile = nil
+	g.rmu.Lock()
+	defer g.rmu.Unlock()
+	if g.reader != nil {
+		g.reader.Close()
+		g.reader = nil
 	}
 }
 
 func (g *Gian) Read() ([]byte, error) {
-	g.mu.Lock()
-	defer g.mu.Unlock()
-	return g.read()
+	return g.ReadContext(context.Background())
 }
 
-func (g *Gian) read() ([]byte, error) {
-	if g.rfile == nil {
-		if err := g.openFile(); err != nil {
-			return nil, err
-		}
+// ReadContext is Read that gives up when ctx is done, the next read goes on
+// from the last record returned
+func (g *Gian) ReadContext(ctx context.Context) ([]byte, error) {
+	g.rmu.Lock()
+	defer g.rmu.Unlock()
+	return g.read(ctx)
+}
 
-		// read first checksum
-		g.rr.Read(g.lastReadCheckSumB[:])
-		bakf, err := os.OpenFile(g.filename+".bak", os.O_RDONLY|os.O_CREATE, 0644)
-		if err != nil && !os.IsNotExist(err) {
-			return nil, err
-		}
-		defer bakf.Close()
-		bakrr, err := NewRReaderSize(bakf, g.chunkSize)
+// read returns the next record of the shared cursor, which sees the log as
+// it was at the first read after Reset
+func (g *Gian) read(ctx context.Context) ([]byte, error) {
+	if g.reader == nil {
+		r, err := g.newReader(ctx, g.isolation)
 		if err != nil {
 			return nil, err
 		}
-		// read first checksum
-		bakcsb := [4]byte{}
-		bakrr.Read(bakcsb[:])
-		if !bytes.Equal(bakcsb[:], g.lastReadCheckSumB[:]) {
-			if g.rfile != nil {
-				g.rfile.Close()
-				g.rfile = nil
-			}
-			if err := g.fix(); err != nil {
-				return nil, err
-			}
-			return g.read()
-		}
-
-		if g.uncommitLength > 0 {
-			return g.uncommitBuffer[:g.uncommitLength], nil
-		}
-	}
-
-	lenb := [4]byte{}
-	if _, err := g.rr.Read(lenb[:]); err != nil {
-		return nil, err
-	}
-	l := binary.BigEndian.Uint32(lenb[:])
-	if l > ONEGB { // 1GB {
-		return g.fixThenRead("wrong len")
-	}
-
-	readBuffer := g.readBuffer
-	if int(l) > len(g.readBuffer) {
-		readBuffer = make([]byte, l)
-	}
-
-	if _, err := g.rr.Read(readBuffer[:l]); err != nil {
-		return nil, err
-	}
-
-	if _, err := g.rr.Read(lenb[:]); err != nil {
-		if err == io.EOF {
-			return g.fixThenRead("here" + err.Error())
-		}
-		return nil, err
+		g.reader = r
 	}
+	return g.reader.ReadContext(ctx)
+}
 
-	l2 := binary.BigEndian.Uint32(lenb[:])
-	if l2 != l {
-		return g.fixThenRead("wrong len2")
-	}
-
-	indexb := [8]byte{}
-	if _, err := g.rr.Read(indexb[:]); err != nil {
-		return nil, err
-	}
-	index := int(binary.BigEndian.Uint64(indexb[:]))
-
-	data := readBuffer[0:l]
-	if index == 1 {
-		// do extra read must be eof
-		onebyte := []byte{0}
-		if n, _ := g.rr.Read(onebyte[:]); n != 0 {
-			return g.fixThenRead("no extra byte")
-		}
-		return data, nil
-	}
-	// do check sum
-	crc := crc32.NewIEEE()
-
-	prevchecksumb := [4]byte{}
-	if _, err := g.rr.Read(prevchecksumb[:]); err != nil {
-		return nil, err
-	}
-	crc.Write(prevchecksumb[:])
-	crc.Write(indexb[:])
-	crc.Write(lenb[:])
-	crc.Write(data[:])
-	crc.Write(lenb[:])
-
-	// confirm the checksum
-	checksum := binary.BigEndian.Uint32(g.lastReadCheckSumB[:])
-	if checksum != crc.Sum32() {
-		return g.fixThenRead("wrong checksum")
-	}
-	g.lastReadCheckSumB = prevchecksumb
-
-	if g.lastReadIndex != 0 {
-		if index+1 != g.lastReadIndex {
-			return g.fixThenRead("wrong index")
-		}
+// replaceWithCopy replaces the file named dst with a synced copy of src,
+// written next to it then renamed over it, so files opened on dst before
+// keep the content they had
+func replaceWithCopy(dst, src string) error {
+	tmp := dst + ".fix.tmp"
+	if err := CopyFile(tmp, src); err != nil {
+		os.Remove(tmp)
+		return err
 	}
-	g.lastReadIndex = int(index)
-
-	return data, nil
+	return os.Rename(tmp, dst)
 }
 
 // CopyFile copies the contents of the file named src to the file named
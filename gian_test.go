@@ -2,13 +2,16 @@ package gian
 
 import (
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"math/rand"
 	"os"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestLayout(t *testing.T) {
@@ -961,3 +964,251 @@ func TestHealingBothMainAndBackup(t *testing.T) {
 	}
 	gian.Close()
 }
+
+func TestAppend(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_append_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	index, err := gian.Append([]byte("one"))
+	if err != nil || index != 1 {
+		t.Errorf("SHOULDEQ, got %d %v, want 1", index, err)
+	}
+
+	// buffered writes take their own index before the appended record
+	gian.Write([]byte("two"))
+	index, err = gian.Append([]byte("three"))
+	if err != nil || index != 3 {
+		t.Errorf("SHOULDEQ, got %d %v, want 3", index, err)
+	}
+	index, err = gian.Append(nil)
+	if err != nil || index != 4 {
+		t.Errorf("SHOULDEQ, got %d %v, want 4", index, err)
+	}
+	gian.Close()
+
+	gian = New(filename)
+	defer gian.Close()
+	if committed, err := gian.CommittedIndex(); err != nil || committed != 4 {
+		t.Errorf("SHOULDEQ, got %d %v, want 4", committed, err)
+	}
+	if index, err := ReadFromStart(filename, nil); err != nil || index != 4 {
+		t.Errorf("MUST BE TRUE %d %v", index, err)
+	}
+	out, err := gian.ReadAll()
+	if err != nil {
+		panic(err)
+	}
+	if string(out) != "threetwoone" {
+		t.Errorf("SHOULDEQ, got %s", out)
+	}
+}
+
+func TestAppendIfLast(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_append_if_last_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := NewWithOptions(filename, Options{GroupCommit: true})
+	defer gian.Close()
+	if index, err := gian.AppendIfLast(0, []byte("one")); err != nil || index != 1 {
+		t.Errorf("SHOULDEQ 1, got %d %v", index, err)
+	}
+	if _, err := gian.AppendIfLast(0, []byte("stale")); err != ErrConflict {
+		t.Errorf("SHOULD CONFLICT, got %v", err)
+	}
+
+	// every writer retries on conflict, no update is lost
+	N, M := 8, 50
+	var wg sync.WaitGroup
+	for range N {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range M {
+				for {
+					last, _ := gian.CommittedIndex()
+					_, err := gian.AppendIfLast(last, []byte("x"))
+					if err == nil {
+						break
+					}
+					if err != ErrConflict {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if last, _ := gian.CommittedIndex(); last != uint64(1+N*M) {
+		t.Errorf("SHOULDEQ %d, got %d", 1+N*M, last)
+	}
+}
+
+func TestWaitCommitted(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_wait_committed_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	gian.Append([]byte("one"))
+	if err := gian.WaitCommitted(context.Background(), 1); err != nil {
+		t.Errorf("MUST BE NO ERR %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- gian.WaitCommitted(context.Background(), 3)
+	}()
+	gian.Append([]byte("two"))
+	select {
+	case err := <-done:
+		t.Errorf("MUST WAIT, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	gian.Write([]byte("three"))
+	gian.ForceCommit()
+	if err := <-done; err != nil {
+		t.Errorf("MUST BE NO ERR %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := gian.WaitCommitted(ctx, 4); err != context.DeadlineExceeded {
+		t.Errorf("SHOULD TIME OUT, got %v", err)
+	}
+
+	go func() {
+		done <- gian.WaitCommitted(context.Background(), 4)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	gian.Close()
+	if err := <-done; err != ErrClosed {
+		t.Errorf("SHOULD BE CLOSED, got %v", err)
+	}
+}
+
+func TestReadContext(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_read_context_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	big := make([]byte, 1536*1024)
+	rand.Read(big)
+	gian := NewWithReadLimit(filename, 1) // 1MB/s with a 1MB burst
+	defer gian.Close()
+	gian.Append([]byte("one"))
+	gian.Append(big)
+	gian.Append([]byte("three"))
+
+	if out, err := gian.Read(); err != nil || string(out) != "three" {
+		t.Fatalf("SHOULDEQ three, got %s %v", out, err)
+	}
+
+	// the big record is stuck behind the limiter
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := gian.ReadContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("SHOULD TIME OUT, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := gian.ReadContext(cancelled); err != context.Canceled {
+		t.Fatalf("SHOULD BE CANCELED, got %v", err)
+	}
+
+	// the reader goes on from where it was cancelled
+	out, err := gian.Read()
+	if err != nil || !bytes.Equal(out, big) {
+		t.Fatalf("MUST READ THE BIG RECORD %v", err)
+	}
+	if out, err := gian.Read(); err != nil || string(out) != "one" {
+		t.Fatalf("SHOULDEQ one, got %s %v", out, err)
+	}
+	if _, err := gian.Read(); err != io.EOF {
+		t.Errorf("SHOULD BE EOF, got %v", err)
+	}
+}
+
+func TestFixContext(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_fix_context_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	N := 100
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		gian.Write(b[:])
+		gian.ForceCommit()
+	}
+	cs := checkSumFile(filename + ".bak")
+	cutFileTail(filename, 10)
+	broken := checkSumFile(filename)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gian.FixContext(ctx); err != context.Canceled {
+		t.Errorf("SHOULD BE CANCELED, got %v", err)
+	}
+	if checkSumFile(filename) != broken || checkSumFile(filename+".bak") != cs {
+		t.Errorf("MUST NOT TOUCH THE FILES")
+	}
+	if repairs := gian.Stats().Repairs; repairs != 0 {
+		t.Errorf("MUST NOT COUNT A REPAIR, got %d", repairs)
+	}
+
+	if err := gian.FixContext(context.Background()); err != nil {
+		t.Errorf("MUST BE NO ERR %v", err)
+	}
+	if checkSumFile(filename) != cs {
+		t.Errorf("MUST HEAL")
+	}
+}
+
+func TestWriteContext(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_write_context_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	gian := New(filename)
+	if err := gian.WriteContext(ctx, []byte("dropped")); err != context.Canceled {
+		t.Errorf("SHOULD BE CANCELED, got %v", err)
+	}
+	gian.WriteContext(context.Background(), []byte("kept"))
+	if err := gian.ForceCommitContext(ctx); err != context.Canceled {
+		t.Errorf("SHOULD BE CANCELED, got %v", err)
+	}
+	if err := gian.ForceCommitContext(context.Background()); err != nil {
+		t.Errorf("MUST BE NO ERR %v", err)
+	}
+	gian.Close()
+
+	gian = NewWithOptions(filename, Options{GroupCommit: true})
+	if err := gian.WriteContext(ctx, []byte("dropped")); err != context.Canceled {
+		t.Errorf("SHOULD BE CANCELED, got %v", err)
+	}
+	gian.WriteContext(context.Background(), []byte("group"))
+	gian.Close()
+
+	gian = New(filename)
+	defer gian.Close()
+	out, err := gian.ReadAll()
+	if err != nil || string(out) != "groupkept" {
+		t.Errorf("SHOULDEQ groupkept, got %s %v", out, err)
+	}
+}
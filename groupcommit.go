@@ -0,0 +1,187 @@
+package gian
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+)
+
+type commitReq struct {
+	data  []byte
+	index int
+	err   error
+	done  chan struct{}
+}
+
+// commitQueue collects records until the writer goroutine picks them up,
+// pushing never blocks
+type commitQueue struct {
+	mu     sync.Mutex
+	reqs   []*commitReq
+	closed bool
+	wake   chan struct{}
+}
+
+func newCommitQueue() *commitQueue {
+	return &commitQueue{wake: make(chan struct{}, 1)}
+}
+
+func (q *commitQueue) push(req *commitReq) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return ErrClosed
+	}
+	q.reqs = append(q.reqs, req)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// drain takes everything queued so far, after close it also refuses new
+// records
+func (q *commitQueue) drain(close bool) []*commitReq {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	reqs := q.reqs
+	q.reqs = nil
+	q.closed = q.closed || close
+	return reqs
+}
+
+// remove takes req out of the queue, false if the writer already has it
+func (q *commitQueue) remove(req *commitReq) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, r := range q.reqs {
+		if r == req {
+			q.reqs = append(q.reqs[:i], q.reqs[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// groupAppend queues data and waits until the writer goroutine has
+// committed it
+func (g *Gian) groupAppend(ctx context.Context, data []byte) (uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if ctx.Done() != nil {
+		// the caller may reuse data once it gives up while the writer is
+		// still encoding it
+		data = bytes.Clone(data)
+	}
+	req := &commitReq{data: data, done: make(chan struct{})}
+	if err := g.group.push(req); err != nil {
+		return 0, err
+	}
+	select {
+	case <-req.done:
+		return uint64(req.index), req.err
+	case <-ctx.Done():
+		// the record is dropped if it is still queued, otherwise it is
+		// being written and will land on its own
+		g.group.remove(req)
+		return 0, ctx.Err()
+	}
+}
+
+func (g *Gian) groupCommit() {
+	defer close(g.groupDone)
+
+	for {
+		select {
+		case <-g.group.wake:
+			g.commitBatch(g.group.drain(false))
+		case <-g.stopChan:
+			g.commitBatch(g.group.drain(true))
+			return
+		}
+	}
+}
+
+// commitBatch writes all reqs to main and backup in parallel with one write
+// call each, then completes every req
+func (g *Gian) commitBatch(reqs []*commitReq) {
+	if len(reqs) == 0 {
+		return
+	}
+
+	g.mu.Lock()
+	err := g.writeBatch(reqs)
+	g.mu.Unlock()
+
+	for _, req := range reqs {
+		if err != nil {
+			req.err = err
+		}
+		close(req.done)
+	}
+}
+
+func (g *Gian) writeBatch(reqs []*commitReq) error {
+	if err := g.load(context.Background()); err != nil {
+		return err
+	}
+	if err := g.openWriters(); err != nil {
+		return err
+	}
+
+	size := 0
+	for _, req := range reqs {
+		size += 8 + 4 + len(req.data) + 4 + 4
+	}
+	buf := make([]byte, 0, size)
+	checksums := make([]uint32, len(reqs))
+	checksum := g.lastCheckSum
+	for i, req := range reqs {
+		frame, flags, err := g.stampFrame(req.data)
+		if err != nil {
+			return err
+		}
+		buf, checksum = encodeFrame(buf, checksum, uint64(g.lastWriteIndex+1+i)|flags, frame, uint32(len(frame)))
+		checksums[i] = checksum
+	}
+
+	start := time.Now()
+	var bakErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if _, bakErr = g.wbakfile.Write(buf); bakErr == nil && g.fsync {
+			bakErr = g.wbakfile.Sync()
+		}
+	}()
+	_, err := g.wfile.Write(buf)
+	if err == nil && g.fsync {
+		err = g.wfile.Sync()
+	}
+	wg.Wait()
+	if err == nil {
+		err = bakErr
+	}
+	if err != nil {
+		// a frame may be torn on one side, verify both files before the
+		// next commit
+		g.loaded = false
+		return err
+	}
+	if g.fsync {
+		g.stats.fsync()
+		g.stats.fsync()
+	}
+
+	latency := time.Since(start)
+	for i, req := range reqs {
+		g.stats.commit(latency)
+		g.committed(g.lastWriteIndex+1, checksums[i], len(req.data))
+		req.index = g.lastWriteIndex
+	}
+	return nil
+}
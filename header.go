@@ -0,0 +1,366 @@
+package gian
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// HEADERS is set in the index field of a frame whose data starts with a
+// header block, covered by the frame checksum like the rest of the data:
+//
+//	[ Length u32 ] then fields [ Tag u8 ] [ Length u16 ] [ --- value --- ]
+//
+// Readers strip the block before returning the payload.
+const HEADERS = 1 << 62
+
+// DEFAULT_DEDUP_WINDOW is the number of latest records whose idempotency
+// key is remembered
+const DEFAULT_DEDUP_WINDOW = 10_000
+
+// header field tags
+const (
+	headerKey  = 1 // idempotency key
+	headerTime = 2 // commit time, unix nanoseconds u64
+	headerType = 3 // record type tag
+	headerAttr = 4 // attribute, [ Key length u16 ] [ key ] [ value ]
+)
+
+var errBadHeaders = errors.New("bad record headers")
+
+// header is what the header block of a frame carries
+type header struct {
+	key   string
+	time  int64 // 0 if not stamped
+	typ   string
+	attrs map[string]string
+}
+
+// check makes sure every field of h fits in a header field
+func (h header) check() error {
+	if len(h.key) > 0xffff || len(h.typ) > 0xffff {
+		return errors.New("record headers too long")
+	}
+	for k, v := range h.attrs {
+		if 2+len(k)+len(v) > 0xffff {
+			return errors.New("record headers too long")
+		}
+	}
+	return nil
+}
+
+// appendHeaders encodes h as a header block, attributes sorted by key
+func appendHeaders(buf []byte, h header) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0)
+	if h.key != "" {
+		buf = appendHeaderField(buf, headerKey, []byte(h.key))
+	}
+	if h.time != 0 {
+		buf = appendHeaderField(buf, headerTime, binary.BigEndian.AppendUint64(nil, uint64(h.time)))
+	}
+	if h.typ != "" {
+		buf = appendHeaderField(buf, headerType, []byte(h.typ))
+	}
+	keys := make([]string, 0, len(h.attrs))
+	for k := range h.attrs {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		value := binary.BigEndian.AppendUint16(nil, uint16(len(k)))
+		value = append(value, k...)
+		value = append(value, h.attrs[k]...)
+		buf = appendHeaderField(buf, headerAttr, value)
+	}
+	binary.BigEndian.PutUint32(buf[start:], uint32(len(buf)-start-4))
+	return buf
+}
+
+func appendHeaderField(buf []byte, tag byte, value []byte) []byte {
+	buf = append(buf, tag, 0, 0)
+	binary.BigEndian.PutUint16(buf[len(buf)-2:], uint16(len(value)))
+	return append(buf, value...)
+}
+
+// splitHeaders decodes the header block at the start of data and returns
+// the payload after it, unknown fields are skipped
+func splitHeaders(data []byte) (header, []byte, error) {
+	h := header{}
+	if len(data) < 4 {
+		return h, nil, errBadHeaders
+	}
+	l := binary.BigEndian.Uint32(data)
+	if uint64(l) > uint64(len(data)-4) {
+		return h, nil, errBadHeaders
+	}
+	block, payload := data[4:4+l], data[4+l:]
+	for len(block) > 0 {
+		if len(block) < 3 {
+			return h, nil, errBadHeaders
+		}
+		tag, vl := block[0], int(binary.BigEndian.Uint16(block[1:3]))
+		if len(block) < 3+vl {
+			return h, nil, errBadHeaders
+		}
+		value := block[3 : 3+vl]
+		switch tag {
+		case headerKey:
+			h.key = string(value)
+		case headerTime:
+			if len(value) != 8 {
+				return h, nil, errBadHeaders
+			}
+			h.time = int64(binary.BigEndian.Uint64(value))
+		case headerType:
+			h.typ = string(value)
+		case headerAttr:
+			if len(value) < 2 || len(value) < 2+int(binary.BigEndian.Uint16(value)) {
+				return h, nil, errBadHeaders
+			}
+			kl := 2 + int(binary.BigEndian.Uint16(value))
+			if h.attrs == nil {
+				h.attrs = map[string]string{}
+			}
+			h.attrs[string(value[2:kl])] = string(value[kl:])
+		}
+		block = block[3+vl:]
+	}
+	return h, payload, nil
+}
+
+// AppendWithKey is Append with an idempotency key stored in the frame. When
+// one of the latest DedupWindow records already has key, nothing is written
+// and its index is returned, so a producer can retry an append safely. An
+// empty key is a plain Append.
+func (g *Gian) AppendWithKey(key string, data []byte) (uint64, error) {
+	if key == "" {
+		return g.Append(data)
+	}
+	index, _, err := g.appendHeaders(header{key: key}, data, false)
+	return index, err
+}
+
+// appendHeaders commits data as a frame with headers h, deduplicated when
+// h has a key, and returns its index and where it starts in the main file.
+// stamp sets the commit time in the headers.
+func (g *Gian) appendHeaders(h header, data []byte, stamp bool) (uint64, int64, error) {
+	if err := h.check(); err != nil {
+		return 0, 0, err
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.forceCommit(context.Background()); err != nil {
+		return 0, 0, err
+	}
+	if err := g.load(context.Background()); err != nil {
+		return 0, 0, err
+	}
+	if h.key != "" {
+		if err := g.loadKeys(); err != nil {
+			return 0, 0, err
+		}
+		if k, ok := g.keys.get(h.key, g.lastWriteIndex); ok {
+			return uint64(k.index), k.offset, nil
+		}
+	}
+	if stamp {
+		var err error
+		if h.time, err = g.nextStamp(); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	offset := fileSize(g.filename)
+	if err := g.writeHeaders(h, data); err != nil {
+		return 0, 0, err
+	}
+	if h.key != "" {
+		g.keys.add(keyed{h.key, g.lastWriteIndex, offset})
+	}
+	return uint64(g.lastWriteIndex), offset, nil
+}
+
+// writeHeaders writes data as the next frame with headers h
+func (g *Gian) writeHeaders(h header, data []byte) error {
+	frame, err := g.headerFrame(h, data)
+	if err != nil {
+		return err
+	}
+	return g.writeFrameFlags(frame, HEADERS, len(data))
+}
+
+// headerFrame encodes data behind headers h, stamped with the commit time
+// if h has none and Options.StampTime is set
+func (g *Gian) headerFrame(h header, data []byte) ([]byte, error) {
+	if h.time == 0 && g.stampTime {
+		var err error
+		if h.time, err = g.nextStamp(); err != nil {
+			return nil, err
+		}
+	}
+	frame := appendHeaders(make([]byte, 0, 64+len(data)), h)
+	return append(frame, data...), nil
+}
+
+// nextStamp returns the commit time of the next record, commit times only
+// go up, SeekTime relies on it
+func (g *Gian) nextStamp() (int64, error) {
+	if err := g.loadStamp(); err != nil {
+		return 0, err
+	}
+	g.lastStamp = max(time.Now().UnixNano(), g.lastStamp+1)
+	return g.lastStamp, nil
+}
+
+// stampFrame prepends a header block with the commit time to data when
+// Options.StampTime is set, flags is HEADERS then
+func (g *Gian) stampFrame(data []byte) ([]byte, uint64, error) {
+	if !g.stampTime {
+		return data, 0, nil
+	}
+	stamp, err := g.nextStamp()
+	if err != nil {
+		return nil, 0, err
+	}
+	frame := appendHeaders(make([]byte, 0, 16+len(data)), header{time: stamp})
+	return append(frame, data...), HEADERS, nil
+}
+
+// loadStamp reads the latest commit time in the log, walking back from the
+// end to the newest frame with one, so stamps go on from it after a
+// restart or when the clock steps back
+func (g *Gian) loadStamp() error {
+	if g.stampLoaded {
+		return nil
+	}
+	f, err := os.Open(g.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		g.lastStamp, g.stampLoaded = 0, true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	end := fi.Size()
+	for end > 0 {
+		start, _, err := frameBefore(f, end)
+		if err != nil {
+			return err
+		}
+		_, stamp, _, err := frameTime(f, start, end)
+		if err != nil {
+			return err
+		}
+		if stamp != 0 {
+			g.lastStamp, g.stampLoaded = stamp, true
+			return nil
+		}
+		end = start
+	}
+	g.lastStamp, g.stampLoaded = 0, true
+	return nil
+}
+
+// keyWindow remembers the idempotency keys of the latest records
+type keyWindow struct {
+	size  int
+	index map[string]keyed
+	queue []keyed // oldest first
+}
+
+// keyed is a record with an idempotency key
+type keyed struct {
+	key    string
+	index  int
+	offset int64 // where the record starts in the main file
+}
+
+func newKeyWindow(size int) *keyWindow {
+	return &keyWindow{size: size, index: map[string]keyed{}}
+}
+
+func (w *keyWindow) add(k keyed) {
+	w.index[k.key] = k
+	w.queue = append(w.queue, k)
+}
+
+// get returns the record with key if it is among the records after
+// last-size
+func (w *keyWindow) get(key string, last int) (keyed, bool) {
+	for len(w.queue) > 0 && w.queue[0].index <= last-w.size {
+		if w.index[w.queue[0].key] == w.queue[0] {
+			delete(w.index, w.queue[0].key)
+		}
+		w.queue = w.queue[1:]
+	}
+	k, ok := w.index[key]
+	return k, ok
+}
+
+// loadKeys rebuilds the key window from the tail of the main file if it
+// was dropped by a load or a truncation
+func (g *Gian) loadKeys() error {
+	if g.keys != nil {
+		return nil
+	}
+	w := newKeyWindow(g.dedupWindow)
+	f, err := os.Open(g.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		g.keys = w
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	found := []keyed{}
+	end := fi.Size()
+	for range g.dedupWindow {
+		if end == 0 {
+			break
+		}
+		start, head, err := frameBefore(f, end)
+		if err != nil {
+			return err
+		}
+		index, flags := frameIndex(head[:8])
+		if flags&HEADERS != 0 {
+			l, _ := frameLength(head[8:])
+			data := make([]byte, l)
+			if _, err := f.ReadAt(data, start+12); err != nil && err != io.EOF {
+				return err
+			}
+			h, _, err := splitHeaders(data)
+			if err != nil {
+				return err
+			}
+			if h.key != "" {
+				found = append(found, keyed{h.key, index, start})
+			}
+		}
+		end = start
+	}
+	for i := len(found) - 1; i >= 0; i-- {
+		w.add(found[i])
+	}
+	g.keys = w
+	return nil
+}
@@ -0,0 +1,68 @@
+package gian
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+)
+
+func TestAppendWithKey(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_key_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := NewWithOptions(filename, Options{DedupWindow: 3})
+	gian.Append([]byte("plain"))
+	if index, err := gian.AppendWithKey("a", []byte("one")); err != nil || index != 2 {
+		t.Fatalf("SHOULDEQ 2, got %d %v", index, err)
+	}
+	if index, err := gian.AppendWithKey("a", []byte("one")); err != nil || index != 2 {
+		t.Errorf("RETRY SHOULDEQ 2, got %d %v", index, err)
+	}
+	if index, err := gian.AppendWithKey("b", []byte("two")); err != nil || index != 3 {
+		t.Errorf("SHOULDEQ 3, got %d %v", index, err)
+	}
+	gian.Close()
+
+	// the window is rebuilt from the tail of the file
+	gian = NewWithOptions(filename, Options{DedupWindow: 3})
+	defer gian.Close()
+	if index, err := gian.AppendWithKey("a", []byte("one")); err != nil || index != 2 {
+		t.Errorf("RETRY AFTER REOPEN SHOULDEQ 2, got %d %v", index, err)
+	}
+	gian.Append([]byte("x"))
+	gian.Append([]byte("y"))
+	if index, err := gian.AppendWithKey("b", []byte("two")); err != nil || index != 3 {
+		t.Errorf("SHOULD STILL BE IN THE WINDOW, got %d %v", index, err)
+	}
+	// "a" fell out of the window
+	if index, err := gian.AppendWithKey("a", []byte("one")); err != nil || index != 6 {
+		t.Errorf("SHOULDEQ 6, got %d %v", index, err)
+	}
+
+	if index, err := ReadFromStart(filename, nil); err != nil || index != 6 {
+		t.Errorf("SHOULDEQ 6, got %d %v", index, err)
+	}
+	out, err := gian.ReadAll()
+	if err != nil || string(out) != "oneyxtwooneplain" {
+		t.Errorf("SHOULDEQ oneyxtwooneplain, got %q %v", out, err)
+	}
+	rc, err := gian.OpenRecord(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if out, err := io.ReadAll(rc); err != nil || !bytes.Equal(out, []byte("one")) {
+		t.Errorf("SHOULDEQ one, got %q %v", out, err)
+	}
+
+	// a truncated record is no longer a duplicate
+	if err := gian.TruncateTo(5); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := gian.AppendWithKey("a", []byte("one")); err != nil || index != 6 {
+		t.Errorf("SHOULDEQ 6, got %d %v", index, err)
+	}
+}
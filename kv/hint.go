@@ -0,0 +1,192 @@
+package kv
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+
+	"github.com/thanhpk/gian"
+)
+
+var hintMagic = []byte("GKVH")
+
+// hint is the key directory of a log up to its record last, so opening
+// the log only replays the records after it
+type hint struct {
+	last   entry
+	time   int64 // commit time of last, to tell it from a record written over it
+	dead   int
+	keydir map[string]entry
+}
+
+// writeHint saves the key directory of log, whose newest record is last
+//
+//	[ GKVH ] [ Gen ] [ Last index ] [ Last offset ] [ Last time ] [ Dead ] [ Count ]
+//	then per key [ Key length u32 ] [ key ] [ Index ] [ Offset ], then [ CRC u32 ]
+func (db *DB) writeHint(gen uint64, log *gian.Gian, keydir map[string]entry, dead int, last entry) error {
+	stamp := int64(0)
+	if last.index > 0 {
+		rec, err := log.ReadRecordAt(last.index, last.offset)
+		if err != nil {
+			return err
+		}
+		stamp = rec.Time.UnixNano()
+	}
+
+	b := append([]byte{}, hintMagic...)
+	for _, v := range []uint64{gen, last.index, uint64(last.offset), uint64(stamp), uint64(dead), uint64(len(keydir))} {
+		b = binary.BigEndian.AppendUint64(b, v)
+	}
+	for key, e := range keydir {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(key)))
+		b = append(b, key...)
+		b = binary.BigEndian.AppendUint64(b, e.index)
+		b = binary.BigEndian.AppendUint64(b, uint64(e.offset))
+	}
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	return writeFileSync(db.hintFile(gen), b)
+}
+
+// readHint returns the hint of log gen, nil when there is none or when it
+// does not match the log any more
+func (db *DB) readHint(gen uint64) (*hint, error) {
+	b, err := os.ReadFile(db.hintFile(gen))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	h, ok := decodeHint(b, gen)
+	if !ok {
+		return nil, nil
+	}
+	if h.last.index > 0 {
+		rec, err := db.log.ReadRecordAt(h.last.index, h.last.offset)
+		if err != nil || rec.Offset != h.last.offset || rec.Time.UnixNano() != h.time {
+			return nil, nil
+		}
+	}
+	return h, nil
+}
+
+func decodeHint(b []byte, gen uint64) (*hint, bool) {
+	head := len(hintMagic) + 6*8
+	if len(b) < head+4 || string(b[:len(hintMagic)]) != string(hintMagic) {
+		return nil, false
+	}
+	body := b[:len(b)-4]
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(b[len(b)-4:]) {
+		return nil, false
+	}
+	u := func(i int) uint64 {
+		return binary.BigEndian.Uint64(body[len(hintMagic)+8*i:])
+	}
+	if u(0) != gen {
+		return nil, false
+	}
+	h := &hint{
+		last:   entry{u(1), int64(u(2))},
+		time:   int64(u(3)),
+		dead:   int(u(4)),
+		keydir: map[string]entry{},
+	}
+	count := u(5)
+	body = body[head:]
+	for range count {
+		if len(body) < 4 {
+			return nil, false
+		}
+		kl := int(binary.BigEndian.Uint32(body))
+		if len(body) < 4+kl+16 {
+			return nil, false
+		}
+		key := string(body[4 : 4+kl])
+		body = body[4+kl:]
+		h.keydir[key] = entry{binary.BigEndian.Uint64(body), int64(binary.BigEndian.Uint64(body[8:]))}
+		body = body[16:]
+	}
+	if len(body) != 0 {
+		return nil, false
+	}
+	return h, true
+}
+
+// readCurrent returns the generation of the live log, from the newest
+// whole copy of CURRENT, 1 for a new directory
+func (db *DB) readCurrent() (uint64, error) {
+	gen, found := uint64(0), false
+	for _, name := range []string{"CURRENT", "CURRENT.bak"} {
+		b, err := os.ReadFile(filepath.Join(db.dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		found = true
+		if len(b) != 8+4 || crc32.ChecksumIEEE(b[:8]) != binary.BigEndian.Uint32(b[8:]) {
+			continue
+		}
+		gen = max(gen, binary.BigEndian.Uint64(b))
+	}
+	if !found {
+		return 1, nil
+	}
+	if gen == 0 {
+		return 0, errors.New("both copies of CURRENT are damaged")
+	}
+	return gen, nil
+}
+
+// writeCurrent makes gen the live log, one copy of CURRENT after the other
+// so a crash leaves at least one whole
+//
+//	[ Gen ] [ CRC u32 ]
+func (db *DB) writeCurrent(gen uint64) error {
+	b := binary.BigEndian.AppendUint64(nil, gen)
+	b = binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE(b))
+	for _, name := range []string{"CURRENT", "CURRENT.bak"} {
+		if err := writeFileSync(filepath.Join(db.dir, name), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFileSync durably writes b to filename, the rename makes it appear
+// whole or not at all
+func writeFileSync(filename string, b []byte) error {
+	tmp := filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	return syncFile(filepath.Dir(filename))
+}
+
+// syncFile flushes a file or a directory to disk
+func syncFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
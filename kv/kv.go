@@ -0,0 +1,298 @@
+// Package kv is a Bitcask-style key-value store on top of gian. Every Put
+// and Delete appends a record to a gian log, a key directory in memory
+// maps each live key to where its latest value starts, and a merge
+// rewrites the live keys into a new log once most records are dead. The
+// log keeps its backup and heals like any gian log, a value is verified
+// every time it is read.
+package kv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thanhpk/gian"
+)
+
+// ErrNotFound is returned by Get for a key that has no value
+var ErrNotFound = errors.New("key not found")
+
+var errClosed = errors.New("kv is closed")
+
+// record types, the key is the attribute "key"
+const (
+	typePut    = "put"
+	typeDelete = "del"
+)
+
+// MIN_MERGE_DEAD is the number of dead records below which the background
+// merge leaves the log alone
+const MIN_MERGE_DEAD = 1000
+
+// Options configures a DB opened by OpenWithOptions, the zero value is the
+// configuration used by Open
+type Options struct {
+	// how often the background merge checks whether dead records
+	// outnumber live keys, 0 disables it
+	MergeInterval time.Duration
+	// options of the logs, both the live one and the ones merges write
+	Gian gian.Options
+}
+
+// DB is a key-value store kept in a directory
+type DB struct {
+	mu     sync.RWMutex
+	dir    string
+	opts   Options
+	gen    uint64 // the log is log.<gen>
+	log    *gian.Gian
+	keydir map[string]entry
+	dead   int   // records of the log that are not the value of a live key
+	last   entry // the newest record of the log, 0 if empty
+	closed bool
+
+	mergeMu  sync.Mutex // one merge at a time
+	stopChan chan struct{}
+}
+
+// entry is where a record starts in the log
+type entry struct {
+	index  uint64
+	offset int64
+}
+
+func Open(dir string) (*DB, error) {
+	return OpenWithOptions(dir, Options{})
+}
+
+func OpenWithOptions(dir string, opts Options) (*DB, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	db := &DB{dir: dir, opts: opts, stopChan: make(chan struct{})}
+	gen, err := db.readCurrent()
+	if err != nil {
+		return nil, err
+	}
+	// a merge that did not finish, or an old log not removed yet
+	if err := db.removeOtherGens(gen); err != nil {
+		return nil, err
+	}
+	db.gen = gen
+	db.log = gian.NewWithOptions(db.logFile(gen), opts.Gian)
+	if err := db.load(); err != nil {
+		db.log.Close()
+		return nil, err
+	}
+	if opts.MergeInterval > 0 {
+		go db.merger(opts.MergeInterval)
+	}
+	return db, nil
+}
+
+func (db *DB) logFile(gen uint64) string {
+	return filepath.Join(db.dir, "log."+strconv.FormatUint(gen, 10))
+}
+
+func (db *DB) hintFile(gen uint64) string {
+	return filepath.Join(db.dir, "hint."+strconv.FormatUint(gen, 10))
+}
+
+// removeOtherGens removes the logs and hints of every generation but gen
+func (db *DB) removeOtherGens(gen uint64) error {
+	names, err := os.ReadDir(db.dir)
+	if err != nil {
+		return err
+	}
+	for _, de := range names {
+		name := de.Name()
+		var rest string
+		switch {
+		case strings.HasPrefix(name, "log."):
+			rest = name[len("log."):]
+		case strings.HasPrefix(name, "hint."):
+			rest = name[len("hint."):]
+		default:
+			continue
+		}
+		n, _, _ := strings.Cut(rest, ".")
+		g, err := strconv.ParseUint(n, 10, 64)
+		if err != nil || g == gen {
+			continue
+		}
+		if err := os.Remove(filepath.Join(db.dir, name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// load builds the key directory from the hint file, if it still matches
+// the log, and the records after it
+func (db *DB) load() error {
+	hint, err := db.readHint(db.gen)
+	if err != nil {
+		return err
+	}
+
+	keydir := map[string]entry{}
+	seen := map[string]bool{} // keys whose newest record was replayed
+	dead := 0
+	last := entry{}
+
+	r, err := db.log.NewReaderIsolation(context.Background(), gian.ReadCommitted)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	for {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hint != nil && rec.Index <= hint.last.index {
+			break
+		}
+		if last.index == 0 {
+			last = entry{rec.Index, rec.Offset}
+		}
+		key, ok := rec.Attrs["key"]
+		if !ok || rec.Type != typePut && rec.Type != typeDelete {
+			continue
+		}
+		if seen[key] || rec.Type == typeDelete {
+			dead++
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		if rec.Type == typePut {
+			keydir[key] = entry{rec.Index, rec.Offset}
+		}
+	}
+
+	if hint != nil {
+		for key, e := range hint.keydir {
+			if seen[key] {
+				dead++
+				continue
+			}
+			keydir[key] = e
+		}
+		dead += hint.dead
+		if last.index == 0 {
+			last = hint.last
+		}
+	}
+	db.keydir, db.dead, db.last = keydir, dead, last
+	return nil
+}
+
+// Get returns the value of key, ErrNotFound if it has none
+func (db *DB) Get(key string) ([]byte, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		return nil, errClosed
+	}
+	e, ok := db.keydir[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	rec, err := db.log.ReadRecordAt(e.index, e.offset)
+	if err != nil {
+		return nil, err
+	}
+	if rec.Type != typePut || rec.Attrs["key"] != key {
+		return nil, fmt.Errorf("record %d is not the value of %q", e.index, key)
+	}
+	return rec.Data, nil
+}
+
+// Put sets the value of key, it is on both files of the log when Put
+// returns
+func (db *DB) Put(key string, value []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return errClosed
+	}
+	index, offset, err := db.log.AppendRecordOffset(gian.Record{Type: typePut, Attrs: map[string]string{"key": key}, Data: value})
+	if err != nil {
+		return err
+	}
+	if _, ok := db.keydir[key]; ok {
+		db.dead++
+	}
+	db.keydir[key] = entry{index, offset}
+	db.last = entry{index, offset}
+	return nil
+}
+
+// Delete removes key, a key without value is left alone
+func (db *DB) Delete(key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return errClosed
+	}
+	if _, ok := db.keydir[key]; !ok {
+		return nil
+	}
+	index, offset, err := db.log.AppendRecordOffset(gian.Record{Type: typeDelete, Attrs: map[string]string{"key": key}})
+	if err != nil {
+		return err
+	}
+	delete(db.keydir, key)
+	db.dead += 2 // the value and the tombstone
+	db.last = entry{index, offset}
+	return nil
+}
+
+// Keys returns the live keys, sorted
+func (db *DB) Keys() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	keys := make([]string, 0, len(db.keydir))
+	for key := range db.keydir {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Close writes the hint file, so the next Open does not replay the log,
+// and closes the log
+func (db *DB) Close() error {
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return nil
+	}
+	db.closed = true
+	close(db.stopChan)
+
+	if err := db.log.ForceCommit(); err != nil {
+		db.log.Close()
+		return err
+	}
+	err := db.writeHint(db.gen, db.log, db.keydir, db.dead, db.last)
+	if cerr := db.log.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,179 @@
+package kv
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func check(t *testing.T, db *DB, want map[string]string) {
+	t.Helper()
+	for key, value := range want {
+		got, err := db.Get(key)
+		if value == "" {
+			if err != ErrNotFound {
+				t.Errorf("%s SHOULD BE NOT FOUND, got %q %v", key, got, err)
+			}
+			continue
+		}
+		if err != nil || string(got) != value {
+			t.Errorf("%s SHOULDEQ %q, got %q %v", key, value, got, err)
+		}
+	}
+}
+
+func TestKV(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_kv_*")
+	defer os.RemoveAll(dir)
+
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{}
+	for i := range 100 {
+		key := fmt.Sprintf("k%d", i%10)
+		value := fmt.Sprintf("v%d", i)
+		db.Put(key, []byte(value))
+		want[key] = value
+	}
+	db.Delete("k3")
+	want["k3"] = ""
+	db.Delete("missing")
+	check(t, db, want)
+	if keys := db.Keys(); len(keys) != 9 {
+		t.Errorf("SHOULDEQ 9 keys, got %v", keys)
+	}
+	db.Close()
+
+	// from the hint file
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, db, want)
+	db.Put("k0", []byte("after hint"))
+	want["k0"] = "after hint"
+	if db.dead != 93 {
+		t.Errorf("SHOULDEQ 93 dead, got %d", db.dead)
+	}
+	db.log.ForceCommit()
+
+	// crash, the hint covers part of the log
+	db2, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, db2, want)
+	if db2.dead != 93 {
+		t.Errorf("SHOULDEQ 93 dead, got %d", db2.dead)
+	}
+	db2.Close()
+	db.Close()
+
+	// without the hint file
+	os.Remove(filepath.Join(dir, "hint.1"))
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(t, db, want)
+
+	before, _ := os.Stat(filepath.Join(dir, "log.1"))
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	check(t, db, want)
+	after, err := os.Stat(filepath.Join(dir, "log.2"))
+	if err != nil || after.Size() >= before.Size()/5 {
+		t.Errorf("MUST COMPACT, %d -> %v %v", before.Size(), after, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "log.1")); !os.IsNotExist(err) {
+		t.Errorf("MUST REMOVE THE OLD LOG, got %v", err)
+	}
+	if db.dead != 0 || len(db.keydir) != 9 {
+		t.Errorf("SHOULDEQ 0 dead 9 keys, got %d %d", db.dead, len(db.keydir))
+	}
+
+	db.Delete("k5")
+	want["k5"] = ""
+	db.Close()
+	db, err = Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(t, db, want)
+}
+
+func TestKVHealing(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_kv_healing_*")
+	defer os.RemoveAll(dir)
+
+	db, _ := Open(dir)
+	defer db.Close()
+	db.Put("a", []byte("first value"))
+	db.Put("b", []byte("second value"))
+
+	// flip a byte of the value of a in the main file
+	filename := filepath.Join(dir, "log.1")
+	b, _ := os.ReadFile(filename)
+	i := len(b) / 4
+	b[i] = ^b[i]
+	os.WriteFile(filename, b, 0644)
+
+	check(t, db, map[string]string{"a": "first value", "b": "second value"})
+	main, _ := os.ReadFile(filename)
+	bak, _ := os.ReadFile(filename + ".bak")
+	if string(main) != string(bak) {
+		t.Errorf("MUST HEAL")
+	}
+}
+
+func TestKVMergeWhileWriting(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_kv_merge_*")
+	defer os.RemoveAll(dir)
+
+	db, _ := Open(dir)
+	for i := range 1000 {
+		db.Put(fmt.Sprintf("k%d", i%50), []byte(fmt.Sprintf("v%d", i)))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range 200 {
+			db.Put(fmt.Sprintf("k%d", i%60), []byte(fmt.Sprintf("w%d", i)))
+			if i%7 == 0 {
+				db.Delete(fmt.Sprintf("k%d", i%60))
+			}
+		}
+	}()
+	if err := db.Merge(); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	want := map[string]string{}
+	for _, key := range db.Keys() {
+		value, err := db.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want[key] = string(value)
+	}
+	db.Close()
+
+	// a fresh replay agrees with the key directory
+	os.Remove(filepath.Join(dir, "hint.2"))
+	db, err := Open(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	check(t, db, want)
+	if len(db.Keys()) != len(want) {
+		t.Errorf("SHOULDEQ %d keys, got %d", len(want), len(db.Keys()))
+	}
+}
@@ -0,0 +1,177 @@
+package kv
+
+import (
+	"context"
+	"io"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/thanhpk/gian"
+)
+
+// Merge rewrites the live keys into a new log and drops the old one. The
+// copy is made while Put, Get and Delete go on, they only wait for the
+// records written meanwhile to be copied and for the switch. A crash
+// before the switch leaves the old log live, the new one is removed by
+// the next Open.
+func (db *DB) Merge() error {
+	db.mergeMu.Lock()
+	defer db.mergeMu.Unlock()
+
+	db.mu.RLock()
+	if db.closed {
+		db.mu.RUnlock()
+		return errClosed
+	}
+	old, gen := db.log, db.gen
+	keydir := maps.Clone(db.keydir)
+	copied := db.last.index
+	db.mu.RUnlock()
+
+	next := gen + 1
+	if err := db.removeOtherGens(gen); err != nil {
+		return err
+	}
+	log := gian.NewWithOptions(db.logFile(next), db.opts.Gian)
+	abort := func(err error) error {
+		log.Close()
+		db.removeOtherGens(gen)
+		return err
+	}
+
+	m := &merged{log: log, keydir: map[string]entry{}}
+	keys := slices.Sorted(maps.Keys(keydir))
+	for _, key := range keys {
+		e := keydir[key]
+		rec, err := old.ReadRecordAt(e.index, e.offset)
+		if err != nil {
+			return abort(err)
+		}
+		if err := m.put(key, rec.Data); err != nil {
+			return abort(err)
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.closed {
+		return abort(errClosed)
+	}
+	// copy what was written during the copy, oldest first
+	recent := []*gian.Record{}
+	if db.last.index > copied {
+		r, err := old.NewReaderIsolation(context.Background(), gian.ReadCommitted)
+		if err != nil {
+			return abort(err)
+		}
+		for {
+			rec, err := r.ReadRecord()
+			if err == io.EOF || err == nil && rec.Index <= copied {
+				break
+			}
+			if err != nil {
+				r.Close()
+				return abort(err)
+			}
+			rec.Data = slices.Clone(rec.Data)
+			recent = append(recent, rec)
+		}
+		r.Close()
+	}
+	for _, rec := range slices.Backward(recent) {
+		key, ok := rec.Attrs["key"]
+		if !ok {
+			continue
+		}
+		var err error
+		switch rec.Type {
+		case typePut:
+			err = m.put(key, rec.Data)
+		case typeDelete:
+			err = m.delete(key)
+		}
+		if err != nil {
+			return abort(err)
+		}
+	}
+
+	// both files of the new log are on disk before it goes live
+	if err := log.Close(); err != nil {
+		return abort(err)
+	}
+	for _, filename := range []string{db.logFile(next), db.logFile(next) + ".bak"} {
+		if err := syncFile(filename); err != nil {
+			return abort(err)
+		}
+	}
+	if err := db.writeCurrent(next); err != nil {
+		return abort(err)
+	}
+
+	old.Close()
+	db.gen = next
+	db.log = gian.NewWithOptions(db.logFile(next), db.opts.Gian)
+	db.keydir, db.dead, db.last = m.keydir, m.dead, m.last
+	if err := db.removeOtherGens(next); err != nil {
+		return err
+	}
+	return db.writeHint(next, db.log, db.keydir, db.dead, db.last)
+}
+
+// merged is the state of the log a merge writes
+type merged struct {
+	log    *gian.Gian
+	keydir map[string]entry
+	dead   int
+	last   entry
+}
+
+func (m *merged) put(key string, value []byte) error {
+	index, offset, err := m.log.AppendRecordOffset(gian.Record{Type: typePut, Attrs: map[string]string{"key": key}, Data: value})
+	if err != nil {
+		return err
+	}
+	if _, ok := m.keydir[key]; ok {
+		m.dead++
+	}
+	m.keydir[key] = entry{index, offset}
+	m.last = entry{index, offset}
+	return nil
+}
+
+func (m *merged) delete(key string) error {
+	if _, ok := m.keydir[key]; !ok {
+		return nil
+	}
+	index, offset, err := m.log.AppendRecordOffset(gian.Record{Type: typeDelete, Attrs: map[string]string{"key": key}})
+	if err != nil {
+		return err
+	}
+	delete(m.keydir, key)
+	m.dead += 2
+	m.last = entry{index, offset}
+	return nil
+}
+
+// merger merges the log in the background once dead records outnumber
+// the live keys
+func (db *DB) merger(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-db.stopChan:
+			return
+		case <-ticker.C:
+		}
+		db.mu.RLock()
+		due := db.dead >= MIN_MERGE_DEAD && db.dead > len(db.keydir)
+		db.mu.RUnlock()
+		if due {
+			// a failed merge leaves the old log live, the next tick tries
+			// again
+			db.Merge()
+		}
+	}
+}
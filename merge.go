@@ -0,0 +1,301 @@
+package gian
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"iter"
+	"os"
+	"strconv"
+	"time"
+)
+
+// attributes Merge sets on every record when MergeOptions.KeepSource is
+// set, the path of the log it comes from as given and its index there
+const (
+	MERGE_SOURCE       = "gian.source"
+	MERGE_SOURCE_INDEX = "gian.source-index"
+)
+
+// MERGE_TIME is the attribute holding the commit time of a record, in
+// RFC 3339 with nanoseconds, when Merge had to move it forward
+const MERGE_TIME = "gian.time"
+
+// MergeOptions configures Merge, the zero value copies the logs one after
+// the other
+type MergeOptions struct {
+	// interleave the records of the sources by commit time, records
+	// without one stay right after the record before them in their log
+	ByTime bool
+	// set MERGE_SOURCE and MERGE_SOURCE_INDEX on every record
+	KeepSource bool
+	// options of the merged log
+	Options Options
+}
+
+// Merge writes the records of the logs at sources, oldest first, into a
+// new log at dst, which must not exist, and returns the number of records
+// written. Records keep their data and headers, commit time included, and
+// are chained anew, the records of a batch stay one unit. Commit times
+// only go up in a log, SeekTime relies on it, a record older than the one
+// before it in dst takes its time and keeps its own in MERGE_TIME. Without
+// ByTime every record of a source comes before the ones of the next
+// source, with it a batch goes by the time of its first record. Idempotency keys are kept but
+// not deduplicated across sources. Sources are verified and repaired like
+// in OpenRecord. Both files of dst are synced before Merge returns, a
+// failed merge removes them.
+func Merge(dst string, sources []string, opts MergeOptions) (uint64, error) {
+	return MergeContext(context.Background(), dst, sources, opts)
+}
+
+func MergeContext(ctx context.Context, dst string, sources []string, opts MergeOptions) (uint64, error) {
+	for _, name := range []string{dst, dst + ".bak"} {
+		if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			if err == nil {
+				return 0, os.ErrExist
+			}
+			return 0, err
+		}
+	}
+	logs := make([]*Gian, 0, len(sources))
+	defer func() {
+		for _, g := range logs {
+			g.Close()
+		}
+	}()
+	for _, src := range sources {
+		// a log lives on while either of its files does
+		if _, err := os.Stat(src); err != nil {
+			if _, berr := os.Stat(src + ".bak"); berr != nil {
+				return 0, err
+			}
+		}
+		logs = append(logs, New(src))
+	}
+
+	out := NewWithOptions(dst, opts.Options)
+	count, err := mergeInto(ctx, out, sources, logs, opts)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && !opts.Options.Fsync {
+		for _, name := range []string{dst, dst + ".bak"} {
+			if err = syncFile(name); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		removeLog(dst)
+		return 0, err
+	}
+	return count, nil
+}
+
+// mergeInto copies the records of logs into out in the order opts asks
+// for
+func mergeInto(ctx context.Context, out *Gian, sources []string, logs []*Gian, opts MergeOptions) (uint64, error) {
+	// the next record of each source with the ones batched with it, nil
+	// once it is done
+	type head struct {
+		unit []*Record
+		time int64
+		next func() (*Record, error, bool)
+	}
+	heads := make([]*head, len(logs))
+	for i, g := range logs {
+		next, stop := iter.Pull2(g.Records(ctx, 1))
+		defer stop()
+		heads[i] = &head{next: next}
+	}
+	advance := func(h *head) error {
+		h.unit = nil
+		for {
+			rec, err, ok := h.next()
+			if !ok {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if len(h.unit) == 0 && !rec.Time.IsZero() {
+				h.time = rec.Time.UnixNano()
+			}
+			h.unit = append(h.unit, rec)
+			if !rec.batched {
+				return nil
+			}
+		}
+	}
+	for _, h := range heads {
+		if err := advance(h); err != nil {
+			return 0, err
+		}
+	}
+
+	var count uint64
+	for {
+		// without ByTime the first source not done goes first, with it the
+		// earliest one, ties kept in the order of sources
+		pick := -1
+		for i, h := range heads {
+			if h.unit == nil {
+				continue
+			}
+			if pick == -1 {
+				pick = i
+				if !opts.ByTime {
+					break
+				}
+				continue
+			}
+			if h.time < heads[pick].time {
+				pick = i
+			}
+		}
+		if pick == -1 {
+			return count, nil
+		}
+
+		unit := heads[pick].unit
+		if opts.KeepSource {
+			for _, rec := range unit {
+				attrs := make(map[string]string, len(rec.Attrs)+2)
+				for k, v := range rec.Attrs {
+					attrs[k] = v
+				}
+				attrs[MERGE_SOURCE] = sources[pick]
+				attrs[MERGE_SOURCE_INDEX] = strconv.FormatUint(rec.Index, 10)
+				rec.Attrs = attrs
+			}
+		}
+		var err error
+		if len(unit) == 1 {
+			_, err = out.appendCopy(ctx, unit[0])
+		} else {
+			_, err = out.appendBatchCopy(ctx, unit)
+		}
+		if err != nil {
+			return 0, err
+		}
+		count += uint64(len(unit))
+		if err := advance(heads[pick]); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// plainRecord tells whether rec has no headers
+func plainRecord(rec *Record) bool {
+	return rec.Key == "" && rec.Time.IsZero() && rec.Type == "" && len(rec.Attrs) == 0
+}
+
+// appendCopy commits rec as the next record with its headers as they are,
+// commit time included unless it is older than the latest one, and without
+// deduplicating its key. A record without headers is written as it was, a
+// large one as a stream.
+func (g *Gian) appendCopy(ctx context.Context, rec *Record) (uint64, error) {
+	if plainRecord(rec) {
+		if len(rec.Data) > STREAM_CHUNKSIZE {
+			return g.AppendStreamContext(ctx, bytes.NewReader(rec.Data))
+		}
+		return g.Append(rec.Data)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.forceCommit(ctx); err != nil {
+		return 0, err
+	}
+	if err := g.load(ctx); err != nil {
+		return 0, err
+	}
+	if err := g.loadStamp(); err != nil {
+		return 0, err
+	}
+	h, err := g.copyHeader(rec)
+	if err != nil {
+		return 0, err
+	}
+	offset := fileSize(g.filename)
+	if err := g.writeHeaders(h, rec.Data); err != nil {
+		return 0, err
+	}
+	if h.key != "" && g.keys != nil {
+		g.keys.add(keyed{h.key, g.lastWriteIndex, offset})
+	}
+	g.lastStamp = max(g.lastStamp, h.time)
+	return uint64(g.lastWriteIndex), nil
+}
+
+// appendBatchCopy is appendCopy for records batched together, they are
+// committed as one unit like in AppendBatch
+func (g *Gian) appendBatchCopy(ctx context.Context, recs []*Record) (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if err := g.forceCommit(ctx); err != nil {
+		return 0, err
+	}
+	if err := g.load(ctx); err != nil {
+		return 0, err
+	}
+	if err := g.loadStamp(); err != nil {
+		return 0, err
+	}
+
+	frames := make([][]byte, len(recs))
+	flags := make([]uint64, len(recs))
+	sizes := make([]int, len(recs))
+	keys := []keyed{}
+	offset := fileSize(g.filename)
+	for i, rec := range recs {
+		var err error
+		if plainRecord(rec) {
+			frames[i], flags[i], err = g.stampFrame(rec.Data)
+		} else {
+			var h header
+			if h, err = g.copyHeader(rec); err == nil {
+				frames[i], err = g.headerFrame(h, rec.Data)
+				flags[i] = HEADERS
+			}
+			if h.key != "" {
+				keys = append(keys, keyed{h.key, g.lastWriteIndex + 1 + i, offset})
+			}
+			g.lastStamp = max(g.lastStamp, h.time)
+		}
+		if err != nil {
+			return 0, err
+		}
+		sizes[i] = len(rec.Data)
+		offset += int64(8 + 4 + len(frames[i]) + 4 + 4)
+	}
+	first, err := g.writeUnit(frames, flags, sizes)
+	if err != nil {
+		return 0, err
+	}
+	if g.keys != nil {
+		for _, k := range keys {
+			g.keys.add(k)
+		}
+	}
+	return first, nil
+}
+
+// copyHeader returns the headers rec is copied with, its commit time moved
+// up to the latest one in the log if older, kept in MERGE_TIME. It must
+// hold the lock, the latest commit time loaded.
+func (g *Gian) copyHeader(rec *Record) (header, error) {
+	h := header{key: rec.Key, typ: rec.Type, attrs: rec.Attrs}
+	if !rec.Time.IsZero() {
+		h.time = rec.Time.UnixNano()
+	}
+	if h.time != 0 && h.time < g.lastStamp {
+		attrs := make(map[string]string, len(h.attrs)+1)
+		for k, v := range h.attrs {
+			attrs[k] = v
+		}
+		attrs[MERGE_TIME] = rec.Time.Format(time.RFC3339Nano)
+		h.attrs, h.time = attrs, g.lastStamp
+	}
+	return h, h.check()
+}
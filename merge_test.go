@@ -0,0 +1,195 @@
+package gian
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMerge(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_merge_*")
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.dat"), filepath.Join(dir, "b.dat")
+
+	ga, gb := New(a), New(b)
+	for i := range 3 {
+		ga.AppendRecord(Record{Type: "a", Data: []byte(fmt.Sprintf("a%d", i))})
+		gb.AppendRecord(Record{Type: "b", Key: fmt.Sprintf("k%d", i), Data: []byte(fmt.Sprintf("b%d", i))})
+	}
+	big := bytes.Repeat([]byte("x"), STREAM_CHUNKSIZE+10)
+	ga.AppendStream(bytes.NewReader(big))
+	ga.Close()
+	gb.Close()
+
+	collect := func(filename string) []*Record {
+		g := New(filename)
+		defer g.Close()
+		recs := []*Record{}
+		for rec, err := range g.Records(context.Background(), 1) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			recs = append(recs, rec)
+		}
+		return recs
+	}
+
+	concat := filepath.Join(dir, "concat.dat")
+	if n, err := Merge(concat, []string{a, b}, MergeOptions{}); err != nil || n != 7 {
+		t.Fatalf("SHOULDEQ 7, got %d %v", n, err)
+	}
+	got := ""
+	for _, rec := range collect(concat) {
+		if len(rec.Data) < 10 {
+			got += string(rec.Data) + " "
+		}
+	}
+	if got != "a0 a1 a2 b0 b1 b2 " {
+		t.Errorf("SHOULDEQ a0 a1 a2 b0 b1 b2, got %s", got)
+	}
+	if checkSumFile(concat) != checkSumFile(concat+".bak") {
+		t.Errorf("BOTH FILES MUST MATCH")
+	}
+
+	merged := filepath.Join(dir, "merged.dat")
+	if n, err := Merge(merged, []string{a, b}, MergeOptions{ByTime: true, KeepSource: true}); err != nil || n != 7 {
+		t.Fatalf("SHOULDEQ 7, got %d %v", n, err)
+	}
+	recs := collect(merged)
+	got = ""
+	for _, rec := range recs {
+		if len(rec.Data) < 10 {
+			got += string(rec.Data) + " "
+		} else {
+			got += "big "
+		}
+	}
+	// the stream has no commit time, it stays after a2
+	if got != "a0 b0 a1 b1 a2 big b2 " {
+		t.Errorf("SHOULDEQ a0 b0 a1 b1 a2 big b2, got %s", got)
+	}
+	if rec := recs[3]; rec.Type != "b" || rec.Key != "k1" || rec.Attrs[MERGE_SOURCE] != b || rec.Attrs[MERGE_SOURCE_INDEX] != "2" {
+		t.Errorf("SHOULD KEEP THE HEADERS, got %+v", rec)
+	}
+	if rec := recs[5]; !bytes.Equal(rec.Data, big) || rec.Attrs[MERGE_SOURCE_INDEX] != "4" {
+		t.Errorf("SHOULD KEEP THE STREAM, got %d bytes %v", len(rec.Data), rec.Attrs)
+	}
+	for i := 1; i < 5; i++ {
+		if recs[i].Time.Before(recs[i-1].Time) {
+			t.Errorf("SHOULD BE ORDERED BY TIME AT %d", i)
+		}
+	}
+
+	if _, err := Merge(merged, []string{a}, MergeOptions{}); !os.IsExist(err) {
+		t.Errorf("SHOULD EXIST, got %v", err)
+	}
+	missing := filepath.Join(dir, "missing.dat")
+	if _, err := Merge(filepath.Join(dir, "c.dat"), []string{a, missing}, MergeOptions{}); !os.IsNotExist(err) {
+		t.Errorf("SHOULD NOT EXIST, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "c.dat")); !os.IsNotExist(err) {
+		t.Errorf("SHOULD NOT WRITE A FAILED MERGE, got %v", err)
+	}
+}
+
+func TestMergeTimes(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_merge_*")
+	defer os.RemoveAll(dir)
+	early, late := filepath.Join(dir, "early.dat"), filepath.Join(dir, "late.dat")
+
+	ge, gl := New(early), New(late)
+	for range 300 {
+		ge.AppendRecord(Record{Data: []byte("early")})
+	}
+	for range 300 {
+		gl.AppendRecord(Record{Data: []byte("late")})
+	}
+	ge.Close()
+	gl.Close()
+	lateRecs := []*Record{}
+	gl = New(late)
+	for rec, err := range gl.Records(context.Background(), 1) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		lateRecs = append(lateRecs, rec)
+	}
+	gl.Close()
+
+	// the later log first, the earlier records must not go back in time
+	merged := filepath.Join(dir, "merged.dat")
+	if _, err := Merge(merged, []string{late, early}, MergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	g := New(merged)
+	defer g.Close()
+	if index, err := g.SeekTime(lateRecs[279].Time); err != nil || index != 280 {
+		t.Errorf("SHOULDEQ 280, got %d %v", index, err)
+	}
+	var prev time.Time
+	for rec, err := range g.Records(context.Background(), 1) {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.Time.Before(prev) {
+			t.Fatalf("SHOULD NOT GO BACK IN TIME AT %d", rec.Index)
+		}
+		prev = rec.Time
+		if rec.Index > 300 && rec.Attrs[MERGE_TIME] == "" {
+			t.Fatalf("SHOULD KEEP THE COMMIT TIME AT %d", rec.Index)
+		}
+	}
+}
+
+func TestMergeBatches(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_merge_*")
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.dat"), filepath.Join(dir, "b.dat")
+
+	ga, gb := New(a), NewWithOptions(b, Options{StampTime: true})
+	ga.Append([]byte("a0"))
+	ga.AppendBatch([][]byte{[]byte("a1"), []byte("a2"), []byte("a3")})
+	ga.Append([]byte("a4"))
+	gb.AppendBatch([][]byte{[]byte("b0"), []byte("b1")})
+	gb.AppendRecord(Record{Key: "k", Data: []byte("b2")})
+	ga.Close()
+	gb.Close()
+
+	for _, opts := range []MergeOptions{{}, {ByTime: true, KeepSource: true}} {
+		merged := filepath.Join(dir, fmt.Sprintf("merged%v.dat", opts.ByTime))
+		if n, err := Merge(merged, []string{a, b}, opts); err != nil || n != 8 {
+			t.Fatalf("SHOULDEQ 8, got %d %v", n, err)
+		}
+		g := New(merged)
+		got, batched := "", []uint64{}
+		for rec, err := range g.Records(context.Background(), 1) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			got += string(rec.Data) + " "
+			if rec.batched {
+				batched = append(batched, rec.Index)
+			}
+		}
+		if got != "a0 a1 a2 a3 a4 b0 b1 b2 " {
+			t.Errorf("SHOULDEQ a0 a1 a2 a3 a4 b0 b1 b2, got %s", got)
+		}
+		// the batches stay one unit, nothing can be cut inside them
+		if fmt.Sprint(batched) != "[2 3 6]" {
+			t.Errorf("SHOULDEQ [2 3 6], got %v", batched)
+		}
+		for _, index := range batched {
+			if err := g.TruncateTo(index); err == nil {
+				t.Errorf("MUST NOT TRUNCATE INSIDE THE BATCH AT %d", index)
+			}
+		}
+		if err := g.TruncateTo(4); err != nil {
+			t.Errorf("MUST TRUNCATE AFTER THE BATCH, got %v", err)
+		}
+		g.Close()
+	}
+}
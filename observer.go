@@ -0,0 +1,50 @@
+package gian
+
+import "fmt"
+
+// CorruptionKind tells what is wrong with a damaged frame
+type CorruptionKind string
+
+const (
+	CorruptMissing     CorruptionKind = "missing file"
+	CorruptTruncated   CorruptionKind = "truncated frame"
+	CorruptWrongIndex  CorruptionKind = "wrong index"
+	CorruptWrongLength CorruptionKind = "wrong length"
+	CorruptChecksum    CorruptionKind = "wrong checksum"
+	CorruptOutOfSync   CorruptionKind = "out of sync"
+)
+
+// CorruptionError is returned when a frame fails verification, Offset is
+// where the damaged frame starts
+type CorruptionError struct {
+	Kind   CorruptionKind
+	Offset int64
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.Kind, e.Offset)
+}
+
+// Observer is notified about what happens inside a Gian. Callbacks run
+// synchronously while the Gian is locked, they must be fast and must not
+// call back into the Gian.
+type Observer interface {
+	// a replica failed verification, offset is where the damage starts
+	OnCorruptionDetected(file string, offset int64, kind CorruptionKind)
+	// both replicas are about to be rewritten, reason is what triggered it
+	OnRepairStarted(reason string)
+	// recovered is the number of frames kept, lost the number of frames
+	// known to exist that could not be recovered from either replica
+	OnRepairFinished(recovered, lost int, err error)
+	// a frame has been written to both replicas
+	OnCommit(index int, bytes int)
+}
+
+// NopObserver ignores every event, embed it to implement only some of the
+// callbacks
+type NopObserver struct{}
+
+func (NopObserver) OnCorruptionDetected(file string, offset int64, kind CorruptionKind) {}
+func (NopObserver) OnRepairStarted(reason string)                                       {}
+func (NopObserver) OnRepairFinished(recovered, lost int, err error)                     {}
+func (NopObserver) OnCommit(index int, bytes int)                                       {}
@@ -0,0 +1,155 @@
+package gian
+
+import (
+	"encoding/binary"
+	"os"
+	"sync"
+	"testing"
+)
+
+type corruptionEvent struct {
+	file   string
+	offset int64
+	kind   CorruptionKind
+}
+
+type recordObserver struct {
+	NopObserver
+	mu          sync.Mutex
+	corruptions []corruptionEvent
+	reasons     []string
+	recovered   int
+	lost        int
+	repairErr   error
+	commits     int
+	lastIndex   int
+}
+
+func (o *recordObserver) OnCorruptionDetected(file string, offset int64, kind CorruptionKind) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.corruptions = append(o.corruptions, corruptionEvent{file, offset, kind})
+}
+
+func (o *recordObserver) OnRepairStarted(reason string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.reasons = append(o.reasons, reason)
+}
+
+func (o *recordObserver) OnRepairFinished(recovered, lost int, err error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.recovered, o.lost, o.repairErr = recovered, lost, err
+}
+
+func (o *recordObserver) OnCommit(index int, bytes int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.commits++
+	o.lastIndex = index
+}
+
+func TestObserverCommit(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_observer_commit_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	obs := &recordObserver{}
+	gian := NewWithOptions(filename, Options{Observer: obs})
+	defer gian.Close()
+	N := 10
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		gian.Write(b[:])
+		gian.ForceCommit()
+	}
+	if obs.commits != N || obs.lastIndex != N {
+		t.Errorf("SHOULDEQ, got %d %d, want %d", obs.commits, obs.lastIndex, N)
+	}
+	if len(obs.reasons) != 0 {
+		t.Errorf("MUST NOT REPAIR %v", obs.reasons)
+	}
+}
+
+func TestObserverRepair(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_observer_repair_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	obs := &recordObserver{}
+	gian := NewWithOptions(filename, Options{Observer: obs})
+	defer gian.Close()
+	N := 10
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		gian.Write(b[:])
+		gian.ForceCommit()
+	}
+
+	// each frame is 24 bytes, break the checksum of the 3rd frame
+	dat, _ := os.ReadFile(filename)
+	dat[2*24+22] ^= 0xff
+	os.WriteFile(filename, dat, 0644)
+	if err := gian.Fix(); err != nil {
+		panic(err)
+	}
+
+	if len(obs.reasons) != 1 || obs.reasons[0] != "manual" {
+		t.Errorf("SHOULD BE MANUAL %v", obs.reasons)
+	}
+	want := corruptionEvent{filename, 2 * 24, CorruptChecksum}
+	if len(obs.corruptions) != 1 || obs.corruptions[0] != want {
+		t.Errorf("SHOULDEQ, got %v, want %v", obs.corruptions, want)
+	}
+	if obs.recovered != N || obs.lost != 0 || obs.repairErr != nil {
+		t.Errorf("MUST RECOVER ALL %d %d %v", obs.recovered, obs.lost, obs.repairErr)
+	}
+
+	// both replicas lose the last 2 frames
+	cutFileTail(filename, 30)
+	cutFileTail(filename+".bak", 30)
+	if err := gian.Fix(); err != nil {
+		panic(err)
+	}
+	if obs.recovered != N-2 || obs.lost != 2 {
+		t.Errorf("SHOULD LOSE 2, got %d %d", obs.recovered, obs.lost)
+	}
+}
+
+func TestObserverRepairOnRead(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_observer_read_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	obs := &recordObserver{}
+	gian := NewWithOptions(filename, Options{Observer: obs})
+	defer gian.Close()
+	N := 10
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		gian.Write(b[:])
+		gian.ForceCommit()
+	}
+
+	// the main file is shorter than the backup, the scan finds it missing
+	os.Remove(filename)
+	for range N {
+		if _, err := gian.Read(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(obs.reasons) == 0 || obs.reasons[0] != string(CorruptOutOfSync) {
+		t.Errorf("SHOULD BE OUT OF SYNC %v", obs.reasons)
+	}
+	want := corruptionEvent{filename, 0, CorruptMissing}
+	if len(obs.corruptions) != 1 || obs.corruptions[0] != want {
+		t.Errorf("SHOULDEQ, got %v, want %v", obs.corruptions, want)
+	}
+}
@@ -2,9 +2,11 @@ package gian
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestConcurrentWrite(t *testing.T) {
@@ -30,3 +32,147 @@ func TestConcurrentWrite(t *testing.T) {
 	g.ForceCommit()
 	g.Close()
 }
+
+func TestConcurrentWriteGroupCommit(t *testing.T) {
+	file, _ := os.CreateTemp("", "concurrent_write_group_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	g := NewWithOptions(filename, Options{GroupCommit: true})
+	var wg sync.WaitGroup
+	N := 100
+	M := 100
+	for i := 0; i < N; i++ {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < M; j++ {
+				if err := g.Write([]byte(fmt.Sprintf("worker-%d-msg-%d", id, j))); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	g.Close()
+
+	if index, err := ReadFromStart(filename, nil); err != nil || index != N*M {
+		t.Errorf("MUST BE TRUE %d %v", index, err)
+	}
+	if checkSumFile(filename) != checkSumFile(filename+".bak") {
+		t.Errorf("MUST BE IN SYNC")
+	}
+	if _, err := g.Append([]byte("late")); err != ErrClosed {
+		t.Errorf("SHOULD BE CLOSED, got %v", err)
+	}
+}
+
+// pausedRead blocks the first read of the main file made without the
+// writer lock until release is closed, closing reading when it gets there
+type pausedRead struct {
+	io.ReadSeeker
+	g       *Gian
+	once    *sync.Once
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (r pausedRead) Read(p []byte) (int, error) {
+	if r.g.mu.TryLock() {
+		r.g.mu.Unlock()
+		r.once.Do(func() {
+			close(r.reading)
+			<-r.release
+		})
+	}
+	return r.ReadSeeker.Read(p)
+}
+
+func TestReadDoesNotBlockWrite(t *testing.T) {
+	file, _ := os.CreateTemp("", "read_no_block_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	g := New(filename)
+	defer g.Close()
+	big := make([]byte, 512*1024)
+	g.Append(big)
+	g.Write([]byte("pending"))
+
+	once, reading, release := &sync.Once{}, make(chan struct{}), make(chan struct{})
+	g.wrapRead = func(rs io.ReadSeeker) io.ReadSeeker {
+		return pausedRead{ReadSeeker: rs, g: g, once: once, reading: reading, release: release}
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		out, err := g.ReadAll()
+		if err != nil || len(out) != len("pending")+len(big) {
+			t.Errorf("SHOULDEQ, got %d %v", len(out), err)
+		}
+	}()
+	select {
+	case <-reading:
+	case <-time.After(10 * time.Second):
+		t.Fatal("READ MUST NOT HOLD THE WRITER LOCK")
+	}
+
+	// the reader is stuck inside a disk read until the writes are done
+	written := make(chan error)
+	go func() {
+		for i := range 10 {
+			if _, err := g.Append([]byte(fmt.Sprintf("msg-%d", i))); err != nil {
+				written <- err
+				return
+			}
+		}
+		written <- nil
+	}()
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("WRITES BLOCKED BY READ")
+	}
+	close(release)
+	<-done
+}
+
+func TestFixWhileWriting(t *testing.T) {
+	file, _ := os.CreateTemp("", "fix_while_writing_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	g := New(filename)
+	N := 1000
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range N {
+			if _, err := g.Append([]byte(fmt.Sprintf("msg-%d", i))); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for range 10 {
+		if err := g.Fix(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	g.Close()
+
+	if index, err := ReadFromStart(filename, nil); err != nil || index != N {
+		t.Errorf("MUST KEEP EVERY FRAME %d %v", index, err)
+	}
+	if checkSumFile(filename) != checkSumFile(filename+".bak") {
+		t.Errorf("MUST BE IN SYNC")
+	}
+}
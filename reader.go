@@ -0,0 +1,503 @@
+package gian
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/thanhpk/vdisk"
+)
+
+// Reader walks the records from the newest to the oldest. It has its own
+// file handle and position, so many readers can scan the log at once
+// without blocking writers, Read or each other. A Reader only sees the
+// records there when it was created, see Isolation.
+type Reader struct {
+	mu sync.Mutex
+	g  *Gian
+
+	file *vdisk.File
+	rctx *ctxReader
+	rr   *RReader
+
+	end   int64  // size of the main file when the reader was created
+	top   int    // index of the newest frame when the reader was created
+	fixes int    // rewrites of the log seen by the reader
+	trunc uint64 // truncations of the log when the reader was created
+	extra []byte // pending record returned before the first frame
+
+	lastReadIndex     int
+	lastReadCheckSumB [4]byte
+	continued         bool   // the last frame read carries CONTINUED
+	header            header // headers of the last frame read
+	offset            int64  // where the last frame read starts
+	readBuffer        []byte
+
+	match func(*Record) bool // records it rejects are skipped, nil takes all
+}
+
+// Isolation tells a reader what it sees of the data written but not
+// committed yet. Either way a reader only sees what was there when it
+// started, later writes and commits show up after Reset or in a new
+// Reader.
+type Isolation int
+
+const (
+	// ReadPending returns the data waiting for the next commit as the
+	// newest record, with the index the commit will give it, before the
+	// committed ones. Records handed to the group commit writer are not
+	// pending, they show up once committed.
+	ReadPending Isolation = iota
+	// ReadCommitted only returns records already on both files
+	ReadCommitted
+)
+
+// NewReader returns a Reader positioned at the newest record, pending ones
+// are included according to Options.Isolation
+func (g *Gian) NewReader() (*Reader, error) {
+	return g.NewReaderContext(context.Background())
+}
+
+func (g *Gian) NewReaderContext(ctx context.Context) (*Reader, error) {
+	return g.newReader(ctx, g.isolation)
+}
+
+// NewReaderIsolation is NewReaderContext with its own isolation instead of
+// Options.Isolation
+func (g *Gian) NewReaderIsolation(ctx context.Context, isolation Isolation) (*Reader, error) {
+	return g.newReader(ctx, isolation)
+}
+
+// newReader takes the snapshot under the lock and reads without it
+func (g *Gian) newReader(ctx context.Context, isolation Isolation) (*Reader, error) {
+	g.mu.Lock()
+	end, top, err := g.snapshot(ctx)
+	fixes, trunc := g.fixes, g.truncations.Load()
+	var extra []byte
+	if err == nil && isolation == ReadPending && g.uncommitLength > 0 {
+		extra = bytes.Clone(g.uncommitBuffer[:g.uncommitLength])
+	}
+	g.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{
+		g:          g,
+		end:        end,
+		top:        top,
+		fixes:      fixes,
+		trunc:      trunc,
+		extra:      extra,
+		readBuffer: make([]byte, g.chunkSize),
+	}
+	if err := r.reopen(ctx, end, top+1); err != nil {
+		var cerr *CorruptionError
+		if !errors.As(err, &cerr) {
+			return nil, err
+		}
+		if _, err := r.repair(ctx, string(cerr.Kind)); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// snapshot returns the size of the main file and the index of its last
+// frame, both files are fixed first if they are out of sync
+func (g *Gian) snapshot(ctx context.Context) (int64, int, error) {
+	if err := g.load(ctx); err != nil {
+		return 0, 0, err
+	}
+	end := fileSize(g.filename)
+	if end != fileSize(g.filename+".bak") {
+		if err := g.fix(ctx, string(CorruptOutOfSync)); err != nil {
+			return 0, 0, err
+		}
+		if err := g.load(ctx); err != nil {
+			return 0, 0, err
+		}
+		end = fileSize(g.filename)
+	}
+	return end, g.lastWriteIndex, nil
+}
+
+// Index returns the index of the last record returned, 0 before the first
+// read
+func (r *Reader) Index() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return uint64(r.lastReadIndex)
+}
+
+// Read returns the next older record, io.EOF after the first one. The
+// returned slice is only valid until the next call.
+func (r *Reader) Read() ([]byte, error) {
+	return r.ReadContext(context.Background())
+}
+
+func (r *Reader) ReadContext(ctx context.Context) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rec, err := r.readRecord(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rec.Data, nil
+}
+
+// ReadRecord is Read returning the record with its headers. Records
+// appended without headers only have Index and Data.
+func (r *Reader) ReadRecord() (*Record, error) {
+	return r.ReadRecordContext(context.Background())
+}
+
+func (r *Reader) ReadRecordContext(ctx context.Context) (*Record, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.readRecord(ctx)
+}
+
+// Filter makes the reader skip the records match returns false for, in
+// Read and ReadRecord. match sees the headers before the caller decodes
+// anything, Data is only valid during the call.
+func (r *Reader) Filter(match func(*Record) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.match = match
+}
+
+func (r *Reader) readRecord(ctx context.Context) (*Record, error) {
+	for {
+		if r.g.truncations.Load() != r.trunc {
+			return nil, ErrTruncated
+		}
+		var rec *Record
+		if r.extra != nil {
+			rec = &Record{Index: uint64(r.top + 1), Offset: -1, Data: r.extra}
+			r.extra = nil
+			r.lastReadIndex = r.top + 1
+		} else {
+			data, err := r.read(ctx)
+			if err != nil {
+				return nil, err
+			}
+			rec = newRecord(uint64(r.lastReadIndex), r.offset, r.header, data)
+		}
+		if r.match == nil || r.match(rec) {
+			r.g.stats.read(1)
+			return rec, nil
+		}
+	}
+}
+
+func (r *Reader) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closeFile()
+}
+
+func (r *Reader) closeFile() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file, r.rctx, r.rr = nil, nil, nil
+	return err
+}
+
+// next is the index the reader expects to read next plus one
+func (r *Reader) next() int {
+	if r.lastReadIndex != 0 {
+		return r.lastReadIndex
+	}
+	return r.top + 1
+}
+
+// reopen opens the main file again and puts the reader right before the
+// newest frame whose index is below next, looking no further than end
+func (r *Reader) reopen(ctx context.Context, end int64, next int) error {
+	r.closeFile()
+	r.g.fmu.RLock()
+	f, err := vdisk.NewLimiter(r.g.limitReadMbs).OpenFile(r.g.filename, os.O_RDONLY|os.O_CREATE, 0644)
+	r.g.fmu.RUnlock()
+	if err != nil {
+		return err
+	}
+	pos, err := frameBoundary(f, end, next)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	var rs io.ReadSeeker = f
+	if r.g.wrapRead != nil {
+		rs = r.g.wrapRead(rs)
+	}
+	r.rctx = &ctxReader{ReadSeeker: rs, ctx: ctx}
+	r.rr = NewRReaderAt(r.rctx, r.g.chunkSize, pos)
+	r.lastReadCheckSumB = [4]byte{}
+	if pos > 0 {
+		if err := readBack(r.rr, r.lastReadCheckSumB[:]); err != nil {
+			r.closeFile()
+			return err
+		}
+	}
+	return nil
+}
+
+// read returns the next older record. The frames of a streamed record are
+// joined in memory, OpenRecord reads one in bounded memory.
+func (r *Reader) read(ctx context.Context) ([]byte, error) {
+	last := r.lastReadIndex
+	data, err := r.readFrame(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	h, offset := r.header, r.offset
+	if r.continued {
+		// the stream this frame belongs to was never finished
+		return nil, &CorruptionError{Kind: CorruptTruncated, Offset: r.rr.Offset()}
+	}
+	more, err := r.prevContinued()
+	if err != nil || !more {
+		return data, err
+	}
+
+	parts := [][]byte{bytes.Clone(data)}
+	for more {
+		if data, err = r.readFrame(ctx, false); err == nil && !r.continued {
+			// the flag peeked was damaged, the frame belongs to an older
+			// record, read it again next time
+			r.closeFile()
+			r.lastReadIndex++
+			break
+		}
+		if err == nil {
+			parts = append(parts, bytes.Clone(data))
+			h, offset = r.header, r.offset
+			more, err = r.prevContinued()
+		}
+		if err != nil {
+			// the next read starts over from the newest frame of the record
+			r.closeFile()
+			r.lastReadIndex = last
+			return nil, err
+		}
+	}
+	r.header, r.offset = h, offset
+	slices.Reverse(parts)
+	return bytes.Join(parts, nil), nil
+}
+
+// prevContinued tells whether the frame before the last one read carries
+// CONTINUED, that is whether the record goes on further back
+func (r *Reader) prevContinued() (bool, error) {
+	if r.lastReadIndex <= 1 {
+		return false, nil
+	}
+	b := [4]byte{}
+	if _, err := r.file.ReadAt(b[:], r.rr.Offset()-4); err != nil {
+		return false, err
+	}
+	_, continued := frameLength(b[:])
+	return continued, nil
+}
+
+// readFrame returns the next older frame
+func (r *Reader) readFrame(ctx context.Context, repaired bool) (data []byte, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	offset := r.end
+	corrupted := func(kind CorruptionKind) ([]byte, error) {
+		if repaired {
+			return nil, &CorruptionError{Kind: kind, Offset: offset}
+		}
+		fixed, err := r.repair(ctx, string(kind))
+		if err != nil {
+			return nil, err
+		}
+		return r.readFrame(ctx, fixed)
+	}
+
+	if r.rr == nil {
+		// cancelled in the middle of a frame or closed
+		if err := r.reopen(ctx, r.end, r.next()); err != nil {
+			var cerr *CorruptionError
+			if errors.As(err, &cerr) {
+				offset = cerr.Offset
+				return corrupted(cerr.Kind)
+			}
+			return nil, err
+		}
+	}
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			r.closeFile()
+			data, err = nil, ctx.Err()
+		}
+	}()
+	r.rctx.ctx = ctx
+
+	offset = r.rr.Offset()
+	if offset == 0 {
+		return nil, io.EOF
+	}
+
+	lenb := [4]byte{}
+	if err := readBack(r.rr, lenb[:]); err != nil {
+		return corruptedOr(err, corrupted)
+	}
+	l, continued := frameLength(lenb[:])
+	if l > ONEGB { // 1GB {
+		return corrupted(CorruptWrongLength)
+	}
+	rawl := binary.BigEndian.Uint32(lenb[:])
+
+	readBuffer := r.readBuffer
+	if int(l) > len(r.readBuffer) {
+		readBuffer = make([]byte, l)
+	}
+	data = readBuffer[:l]
+	if err := readBack(r.rr, data); err != nil {
+		return corruptedOr(err, corrupted)
+	}
+
+	if err := readBack(r.rr, lenb[:]); err != nil {
+		return corruptedOr(err, corrupted)
+	}
+	if binary.BigEndian.Uint32(lenb[:]) != rawl {
+		return corrupted(CorruptWrongLength)
+	}
+
+	indexb := [8]byte{}
+	if err := readBack(r.rr, indexb[:]); err != nil {
+		return corruptedOr(err, corrupted)
+	}
+	index, flags := frameIndex(indexb[:])
+	if index+1 != r.next() {
+		return corrupted(CorruptWrongIndex)
+	}
+
+	prevchecksumb := [4]byte{}
+	if index > 1 {
+		if err := readBack(r.rr, prevchecksumb[:]); err != nil {
+			return corruptedOr(err, corrupted)
+		}
+	} else if r.rr.Offset() != 0 {
+		// data before the first frame
+		return corrupted(CorruptWrongIndex)
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(prevchecksumb[:])
+	crc.Write(indexb[:])
+	crc.Write(lenb[:])
+	crc.Write(data)
+	crc.Write(lenb[:])
+	if binary.BigEndian.Uint32(r.lastReadCheckSumB[:]) != crc.Sum32() {
+		return corrupted(CorruptChecksum)
+	}
+	h := header{}
+	if flags&HEADERS != 0 {
+		if h, data, err = splitHeaders(data); err != nil {
+			r.closeFile()
+			return nil, err
+		}
+	}
+
+	r.lastReadCheckSumB = prevchecksumb
+	r.lastReadIndex = index
+	r.continued = continued
+	r.header = h
+	r.offset = 0
+	if index > 1 {
+		// the checksum of the frame before was read too
+		r.offset = r.rr.Offset() + 4
+	}
+	return data, nil
+}
+
+// repair fixes both files then reopens the reader right after the last
+// record it returned. When the log was rewritten since the reader opened
+// it, the damage may be the rewrite itself, so the reader only reopens and
+// reports false.
+func (r *Reader) repair(ctx context.Context, reason string) (bool, error) {
+	g := r.g
+	g.mu.Lock()
+	if g.truncations.Load() != r.trunc {
+		g.mu.Unlock()
+		return false, ErrTruncated
+	}
+	fixed := g.fixes == r.fixes
+	var err error
+	if fixed {
+		err = g.fix(ctx, reason)
+	}
+	end, fixes := fileSize(g.filename), g.fixes
+	g.mu.Unlock()
+	if err != nil {
+		return fixed, err
+	}
+	// frames the reader has not reached are still bounded by its snapshot,
+	// newer ones are skipped by next
+	r.end, r.fixes = end, fixes
+	return fixed, r.reopen(ctx, end, r.next())
+}
+
+// corruptedOr reports a short read as a truncated frame, other errors are
+// returned as they are
+func corruptedOr(err error, corrupted func(CorruptionKind) ([]byte, error)) ([]byte, error) {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return corrupted(CorruptTruncated)
+	}
+	return nil, err
+}
+
+// readBack reads exactly len(p) bytes going backward
+func readBack(rr *RReader, p []byte) error {
+	n, err := rr.Read(p)
+	if err != nil {
+		return err
+	}
+	if n != len(p) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// frameBoundary walks back from end over whole frames, without verifying
+// them, and returns the end of the newest frame whose index is below next
+func frameBoundary(f io.ReaderAt, end int64, next int) (int64, error) {
+	b := [8]byte{}
+	for end > 0 {
+		// [ N ] [ Length ] [ --- data ---- ] [ Length ] [ CHECKSUM ]
+		if end < 8+4+4+4 {
+			return 0, &CorruptionError{Kind: CorruptTruncated, Offset: 0}
+		}
+		if _, err := f.ReadAt(b[:4], end-8); err != nil {
+			return 0, err
+		}
+		fl, _ := frameLength(b[:4])
+		l := int64(fl)
+		start := end - (8 + 4 + l + 4 + 4)
+		if l > ONEGB || start < 0 {
+			return 0, &CorruptionError{Kind: CorruptWrongLength, Offset: end}
+		}
+		if _, err := f.ReadAt(b[:], start); err != nil {
+			return 0, err
+		}
+		if index, _ := frameIndex(b[:]); index < next {
+			return end, nil
+		}
+		end = start
+	}
+	return 0, nil
+}
@@ -0,0 +1,208 @@
+package gian
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestReaderSnapshot(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_reader_snapshot_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+
+	r, err := gian.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("SHOULD BE EOF, got %v", err)
+	}
+	r.Close()
+
+	gian.Append([]byte("one"))
+	gian.Append([]byte("two"))
+	r, err = gian.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	gian.Append([]byte("three"))
+	gian.Write([]byte("pending"))
+
+	for _, want := range []string{"two", "one"} {
+		out, err := r.Read()
+		if err != nil || string(out) != want {
+			t.Errorf("SHOULDEQ %s, got %s %v", want, out, err)
+		}
+	}
+	if r.Index() != 1 {
+		t.Errorf("SHOULDEQ 1, got %d", r.Index())
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("SHOULD BE EOF, got %v", err)
+	}
+}
+
+func TestConcurrentReaders(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_concurrent_readers_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	N := 1000
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		gian.Append(b[:])
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range N {
+			b := [4]byte{}
+			binary.BigEndian.PutUint32(b[:], uint32(N+i))
+			gian.Append(b[:])
+		}
+	}()
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r, err := gian.NewReader()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer r.Close()
+			last := -1
+			for {
+				b, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				i := int(binary.BigEndian.Uint32(b))
+				if last != -1 && i != last-1 {
+					t.Errorf("SHOULDEQ, got %d, want %d", i, last-1)
+					return
+				}
+				last = i
+			}
+			if last != 0 {
+				t.Errorf("MUST READ TO THE FIRST RECORD, got %d", last)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestReaderHealing(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_reader_healing_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	N := 1000
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		gian.Append(b[:])
+	}
+
+	r, err := gian.NewReader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for i := range N {
+		if i == N/2 {
+			messUpFile(filename)
+		}
+		b, err := r.Read()
+		if err != nil {
+			t.Fatalf("ERR %d %v", i, err)
+		}
+		if readi := binary.BigEndian.Uint32(b); int(readi) != N-i-1 {
+			t.Fatalf("SHOULDEQ, got %d, want %d", readi, N-i-1)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("SHOULD BE EOF, got %v", err)
+	}
+	if checkSumFile(filename) != checkSumFile(filename+".bak") {
+		t.Errorf("MUST HEAL")
+	}
+}
+
+func TestIsolation(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_isolation_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	// ReadPending returns the pending data as the next record
+	gian := New(filename)
+	gian.Append([]byte("one"))
+	gian.Append([]byte("two"))
+	gian.Write([]byte("three"))
+	gian.Write([]byte("four"))
+	out, err := gian.ReadAll()
+	if err != nil || string(out) != "threefourtwoone" {
+		t.Errorf("SHOULDEQ threefourtwoone, got %s %v", out, err)
+	}
+	r, _ := gian.NewReader()
+	if out, _ := r.Read(); string(out) != "threefour" || r.Index() != 3 {
+		t.Errorf("SHOULDEQ threefour 3, got %s %d", out, r.Index())
+	}
+	gian.Write([]byte("five"))
+	if out, _ := r.Read(); string(out) != "two" || r.Index() != 2 {
+		t.Errorf("SHOULDEQ two 2, got %s %d", out, r.Index())
+	}
+	r.Close()
+
+	// the pending record keeps its content and index once committed
+	gian.ForceCommit()
+	gian.Reset()
+	if out, _ := gian.Read(); string(out) != "threefourfive" {
+		t.Errorf("SHOULDEQ threefourfive, got %s", out)
+	}
+	gian.Close()
+
+	// ReadCommitted never sees the pending data
+	gian = NewWithOptions(filename, Options{Isolation: ReadCommitted})
+	defer gian.Close()
+	gian.Write([]byte("six"))
+	out, err = gian.ReadAll()
+	if err != nil || string(out) != "threefourfivetwoone" {
+		t.Errorf("SHOULDEQ threefourfivetwoone, got %s %v", out, err)
+	}
+	r, _ = gian.NewReader()
+	if out, _ := r.Read(); string(out) != "threefourfive" || r.Index() != 3 {
+		t.Errorf("SHOULDEQ threefourfive 3, got %s %d", out, r.Index())
+	}
+	r.Close()
+
+	// a reader may override the mode
+	r, _ = gian.NewReaderIsolation(context.Background(), ReadPending)
+	if out, _ := r.Read(); string(out) != "six" || r.Index() != 4 {
+		t.Errorf("SHOULDEQ six 4, got %s %d", out, r.Index())
+	}
+	r.Close()
+}
@@ -0,0 +1,141 @@
+package gian
+
+import (
+	"context"
+	"io"
+	"iter"
+	"time"
+)
+
+// Record is a record with its headers. The headers travel in the frame
+// with the data, so the checksum covers them, but readers can look at them
+// without decoding Data.
+type Record struct {
+	Index uint64
+	// where the record starts in the main file, -1 for data not committed
+	// yet, see ReadRecordAt
+	Offset int64
+	// commit time, zero for records appended without one, see
+	// Options.StampTime
+	Time time.Time
+	// optional record type tag
+	Type string
+	// optional small key/value attributes
+	Attrs map[string]string
+	// optional idempotency key, see AppendWithKey
+	Key string
+
+	Data []byte
+
+	batched bool // written by AppendBatch with the record after it
+}
+
+func newRecord(index uint64, offset int64, h header, data []byte) *Record {
+	rec := &Record{Index: index, Offset: offset, Type: h.typ, Attrs: h.attrs, Key: h.key, Data: data}
+	if h.time != 0 {
+		rec.Time = time.Unix(0, h.time)
+	}
+	return rec
+}
+
+// AppendRecord commits rec.Data as a frame of its own with the headers of
+// rec and the commit time, Index and Time are ignored. A record with a Key
+// is deduplicated like in AppendWithKey. Type, each key and value of Attrs
+// and Key are limited to 64KB.
+func (g *Gian) AppendRecord(rec Record) (uint64, error) {
+	index, _, err := g.AppendRecordOffset(rec)
+	return index, err
+}
+
+// AppendRecordOffset is AppendRecord also returning where the record
+// starts in the main file, so it can be read again with ReadRecordAt
+func (g *Gian) AppendRecordOffset(rec Record) (uint64, int64, error) {
+	h := header{key: rec.Key, typ: rec.Type, attrs: rec.Attrs}
+	return g.appendHeaders(h, rec.Data, true)
+}
+
+// ReadRecordAt returns the record at index, which starts at offset in the
+// main file as reported by AppendRecordOffset or a Record. Its frames are
+// verified and repaired like in OpenRecord, a wrong offset only costs a
+// search for the record.
+func (g *Gian) ReadRecordAt(index uint64, offset int64) (*Record, error) {
+	return g.ReadRecordAtContext(context.Background(), index, offset)
+}
+
+func (g *Gian) ReadRecordAtContext(ctx context.Context, index uint64, offset int64) (*Record, error) {
+	g.mu.Lock()
+	end, top, err := g.snapshot(ctx)
+	fixes, trunc := g.fixes, g.truncations.Load()
+	g.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if index == 0 || index > uint64(top) {
+		return nil, ErrNoRecord
+	}
+
+	r := &recordReader{
+		g:     g,
+		ctx:   ctx,
+		end:   end,
+		fixes: fixes,
+		trunc: trunc,
+		first: int(index),
+		index: int(index),
+	}
+	defer r.Close()
+	if err := r.seek(offset); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return newRecord(index, r.start, r.header, data), nil
+}
+
+// Records yields the records from index from to the newest one committed
+// when it starts, oldest first. Frames are verified and repaired like in
+// OpenRecord, an error is yielded last.
+func (g *Gian) Records(ctx context.Context, from uint64) iter.Seq2[*Record, error] {
+	return func(yield func(*Record, error) bool) {
+		g.mu.Lock()
+		end, top, err := g.snapshot(ctx)
+		fixes, trunc := g.fixes, g.truncations.Load()
+		g.mu.Unlock()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		from = max(from, 1)
+		if from > uint64(top) {
+			return
+		}
+
+		r, err := g.openRecordReader(ctx, end, fixes, trunc, int(from), 0)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		defer r.Close()
+		for {
+			data, err := io.ReadAll(r)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+			rec := newRecord(uint64(r.first), r.start, r.header, data)
+			rec.batched = r.batched
+			if !yield(rec, nil) {
+				return
+			}
+			if r.index > top {
+				return
+			}
+			if err := r.nextRecord(ctx, r.end, r.fixes); err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+	}
+}
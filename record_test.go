@@ -0,0 +1,96 @@
+package gian
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRecordHeaders(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_record_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	before := time.Now()
+	gian.Append([]byte("plain"))
+	gian.AppendRecord(Record{Type: "order", Attrs: map[string]string{"tenant": "a", "region": "eu"}, Data: []byte("o1")})
+	gian.AppendRecord(Record{Type: "payment", Data: []byte("p1")})
+	gian.AppendRecord(Record{Type: "order", Attrs: map[string]string{"tenant": "b"}, Data: []byte("o2")})
+
+	if index, err := ReadFromStart(filename, nil); err != nil || index != 4 {
+		t.Errorf("SHOULDEQ 4, got %d %v", index, err)
+	}
+
+	r, _ := gian.NewReader()
+	defer r.Close()
+	rec, err := r.ReadRecord()
+	if err != nil || rec.Index != 4 || rec.Type != "order" || rec.Attrs["tenant"] != "b" || string(rec.Data) != "o2" {
+		t.Fatalf("SHOULDEQ o2, got %+v %v", rec, err)
+	}
+	if rec.Time.Before(before) || rec.Time.After(time.Now()) {
+		t.Errorf("WRONG COMMIT TIME %v", rec.Time)
+	}
+	if out, err := r.Read(); err != nil || string(out) != "p1" {
+		t.Errorf("SHOULDEQ p1, got %q %v", out, err)
+	}
+	r.Filter(func(rec *Record) bool { return rec.Type == "order" })
+	rec, err = r.ReadRecord()
+	if err != nil || rec.Index != 2 || rec.Attrs["region"] != "eu" || string(rec.Data) != "o1" {
+		t.Errorf("SHOULDEQ o1, got %+v %v", rec, err)
+	}
+	if _, err := r.ReadRecord(); err != io.EOF {
+		t.Errorf("SHOULD SKIP plain, got %v", err)
+	}
+
+	r, _ = gian.NewReader()
+	defer r.Close()
+	for range 3 {
+		r.Read()
+	}
+	rec, err = r.ReadRecord()
+	if err != nil || rec.Index != 1 || !rec.Time.IsZero() || rec.Type != "" || string(rec.Data) != "plain" {
+		t.Errorf("SHOULDEQ plain, got %+v %v", rec, err)
+	}
+
+	out, err := gian.ReadAll()
+	if err != nil || string(out) != "o2p1o1plain" {
+		t.Errorf("SHOULDEQ o2p1o1plain, got %q %v", out, err)
+	}
+}
+
+func TestReadRecordAt(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_record_at_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	gian.Append([]byte("plain"))
+	index, offset, err := gian.AppendRecordOffset(Record{Type: "t", Data: []byte("one")})
+	if err != nil || index != 2 || offset != 5+20 {
+		t.Fatalf("SHOULDEQ 2 at 25, got %d %d %v", index, offset, err)
+	}
+	gian.AppendRecord(Record{Data: []byte("two")})
+
+	r, _ := gian.NewReader()
+	defer r.Close()
+	r.Read()
+	if rec, err := r.ReadRecord(); err != nil || rec.Offset != offset {
+		t.Errorf("SHOULDEQ %d, got %v", offset, err)
+	}
+
+	for _, at := range []int64{offset, 0, offset + 1, -1, 1 << 40} {
+		rec, err := gian.ReadRecordAt(index, at)
+		if err != nil || rec.Type != "t" || string(rec.Data) != "one" || rec.Offset != offset {
+			t.Errorf("SHOULDEQ one at %d, got %+v %v", at, rec, err)
+		}
+	}
+	if _, err := gian.ReadRecordAt(4, 0); err != ErrNoRecord {
+		t.Errorf("SHOULD BE NO RECORD, got %v", err)
+	}
+}
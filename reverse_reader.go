@@ -33,6 +33,19 @@ func NewRReaderSize(file io.ReadSeeker, size int) (*RReader, error) {
 	}, nil
 }
 
+// NewRReaderAt returns a new [RReader] that reads backward from offset end
+// instead of the end of the file.
+func NewRReaderAt(file io.ReadSeeker, size int, end int64) *RReader {
+	if size <= 0 {
+		size = 4096
+	}
+	return &RReader{
+		filecur: end,
+		buf:     make([]byte, size),
+		file:    file,
+	}
+}
+
 // NewReader returns a new [Reader] whose buffer has the default size.
 func NewRReader(file *os.File) (*RReader, error) {
 	return NewRReaderSize(file, 4096)
@@ -48,7 +61,7 @@ func NewRReader(file *os.File) (*RReader, error) {
 func (b *RReader) Read(p []byte) (n int, err error) {
 	n = len(p)
 	if n == 0 {
-		return 0, b.err
+		return 0, nil
 	}
 	// can read from left-over buffer
 	if len(p) <= b.r {
@@ -102,7 +115,7 @@ func (b *RReader) Read(p []byte) (n int, err error) {
 		// fast path for large read, read directly to avoid copy
 		n, err := b.file.Read(p[byteLeft-nFileReadByte : byteLeft])
 		b.err = err
-		return n, err
+		return nread + n, err
 	}
 
 	n, err = b.file.Read(b.buf[len(b.buf)-nFileReadByte : len(b.buf)])
@@ -119,3 +132,9 @@ func (b *RReader) Read(p []byte) (n int, err error) {
 	}
 	return nread + min(byteLeft, n), nil
 }
+
+// Offset returns the position in the file of the next byte to be read,
+// everything before it is still unread.
+func (b *RReader) Offset() int64 {
+	return b.filecur + int64(b.r)
+}
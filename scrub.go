@@ -0,0 +1,127 @@
+package gian
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/thanhpk/vdisk"
+)
+
+// ScrubReport summarizes one pass of the scrubber over both replicas
+type ScrubReport struct {
+	Start    time.Time
+	Duration time.Duration
+
+	Frames int   // number of healthy frames in the main file
+	Bytes  int64 // number of bytes verified in the main file
+
+	MainErr  error // first corruption found in the main file
+	BakErr   error // first corruption found in the backup file
+	InSync   bool  // main and backup hold the same frames
+	Repaired bool  // a repair was run
+	Err      error // the repair failed
+}
+
+func (r ScrubReport) Healthy() bool {
+	return r.MainErr == nil && r.BakErr == nil && r.InSync && r.Err == nil
+}
+
+func (g *Gian) scrubber(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			g.Scrub()
+		case <-g.stopChan:
+			return
+		}
+	}
+}
+
+// Scrub re-verifies every frame of the main and the backup file and
+// repairs them when one is damaged or they are out of sync. Frames are
+// read at ScrubLimitMbs without holding the lock, the lock is only taken to
+// repair.
+func (g *Gian) Scrub() ScrubReport {
+	var report ScrubReport
+	for {
+		report = ScrubReport{Start: time.Now()}
+
+		// commits append whole frames under the lock, so these sizes end
+		// at a frame boundary
+		g.mu.Lock()
+		filename := g.filename
+		mainSize := fileSize(filename)
+		bakSize := fileSize(filename + ".bak")
+		fixes, trunc, moves := g.fixes, g.truncations.Load(), g.moves
+		g.mu.Unlock()
+
+		limiter := vdisk.NewLimiter(g.scrubLimitMbs)
+		mainIndex, mainErr := scrubFile(limiter, filename, mainSize)
+		bakIndex, bakErr := scrubFile(limiter, filename+".bak", bakSize)
+		report.Frames = mainIndex
+		report.Bytes = mainSize
+		report.MainErr = mainErr
+		report.BakErr = bakErr
+		report.InSync = mainIndex == bakIndex && mainSize == bakSize
+		if report.Healthy() {
+			break
+		}
+
+		g.mu.Lock()
+		if g.fixes != fixes || g.truncations.Load() != trunc || g.moves != moves {
+			// the files were rewritten, cut or moved during the pass, what
+			// it found may be gone, scan them again
+			g.mu.Unlock()
+			continue
+		}
+		select {
+		case <-g.stopChan:
+			// closing, leave the files for the next open
+		default:
+			report.Repaired = true
+			report.Err = g.fix(context.Background(), "scrub")
+		}
+		g.mu.Unlock()
+		break
+	}
+
+	report.Duration = time.Since(report.Start)
+	g.mu.Lock()
+	g.lastScrub = report
+	g.mu.Unlock()
+	return report
+}
+
+// LastScrub returns the report of the latest finished scrub, the zero
+// report if none has run yet
+func (g *Gian) LastScrub() ScrubReport {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.lastScrub
+}
+
+func scrubFile(limiter *vdisk.Limiter, filename string, size int64) (int, error) {
+	f, err := limiter.OpenFile(filename, os.O_RDONLY, 0644)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer f.Close()
+	return readFromStart(context.Background(), io.LimitReader(f, size), nil)
+}
+
+func fileSize(filename string) int64 {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
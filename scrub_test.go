@@ -0,0 +1,138 @@
+package gian
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestScrubHealthy(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_scrub_healthy_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	N := 100
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		gian.Write(b[:])
+		gian.ForceCommit()
+	}
+
+	report := gian.Scrub()
+	if !report.Healthy() || report.Repaired {
+		t.Errorf("MUST BE HEALTHY %+v", report)
+	}
+	if report.Frames != N {
+		t.Errorf("SHOULDEQ, got %d, want %d", report.Frames, N)
+	}
+	if gian.LastScrub().Start != report.Start {
+		t.Errorf("MUST KEEP LAST REPORT")
+	}
+}
+
+func TestScrubRepair(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_scrub_repair_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	N := 1000
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		gian.Write(b[:])
+		gian.ForceCommit()
+	}
+	cs := checkSumFile(filename)
+
+	messUpFile(filename + ".bak")
+	report := gian.Scrub()
+	if report.BakErr == nil || !report.Repaired || report.Err != nil {
+		t.Errorf("MUST REPAIR %+v", report)
+	}
+	if checkSumFile(filename) != cs || checkSumFile(filename+".bak") != cs {
+		t.Errorf("MUST HEAL")
+	}
+
+	// the chain must continue after the repair
+	gian.Write([]byte("after"))
+	gian.ForceCommit()
+	if index, err := ReadFromStart(filename, nil); err != nil || index != N+1 {
+		t.Errorf("MUST BE TRUE %d %v", index, err)
+	}
+}
+
+func TestBackgroundScrubber(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_scrubber_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := NewWithOptions(filename, Options{ScrubInterval: 20 * time.Millisecond, ScrubLimitMbs: 10})
+	defer gian.Close()
+	N := 100
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		gian.Write(b[:])
+		gian.ForceCommit()
+	}
+	cs := checkSumFile(filename + ".bak")
+	cutFileTail(filename, 10)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		// a healthy scrub may replace the report of the repair, count it
+		if gian.Stats().Repairs > 0 && checkSumFile(filename) == cs {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("MUST HEAL IN BACKGROUND %+v", gian.LastScrub())
+}
+
+func TestScrubDuringTruncate(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_scrub_truncate_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	// a slow pass so truncations land while it reads
+	gian := NewWithOptions(filename, Options{ScrubLimitMbs: 1})
+	defer gian.Close()
+	for range 2000 {
+		gian.Append([]byte("some record data"))
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range 20 {
+			for range 20 {
+				gian.Append([]byte("more"))
+			}
+			time.Sleep(15 * time.Millisecond)
+			gian.TruncateTo(2000)
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			if repairs := gian.Stats().Repairs; repairs != 0 {
+				t.Errorf("MUST NOT REPAIR A HEALTHY LOG, got %d repairs", repairs)
+			}
+			return
+		default:
+		}
+		if report := gian.Scrub(); !report.Healthy() {
+			t.Errorf("MUST BE HEALTHY %+v", report)
+		}
+	}
+}
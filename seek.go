@@ -0,0 +1,191 @@
+package gian
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// TIME_INDEX_INTERVAL is the number of timed records between two marks of
+// the time index searched by SeekTime
+const TIME_INDEX_INTERVAL = 256
+
+// timeIndex marks every TIME_INDEX_INTERVAL-th record with a commit time,
+// it is extended with the frames appended since the last search and built
+// again after a rewrite or a truncation
+type timeIndex struct {
+	fixes int
+	trunc uint64
+	end   int64 // frames before end are indexed
+	next  int   // index of the frame at end
+	count int   // timed records before end
+	marks []timeMark
+}
+
+type timeMark struct {
+	index  int
+	offset int64
+	time   int64
+}
+
+// SeekTime returns the index of the oldest record committed at or after t,
+// ErrNoRecord if there is none. Only records appended with AppendRecord,
+// or with any write when Options.StampTime is set, carry a commit time,
+// the others are skipped. The commit times are searched in a sparse index
+// kept in memory, built from the frame headers on the first call.
+func (g *Gian) SeekTime(t time.Time) (uint64, error) {
+	return g.SeekTimeContext(context.Background(), t)
+}
+
+func (g *Gian) SeekTimeContext(ctx context.Context, t time.Time) (uint64, error) {
+	g.tmu.Lock()
+	defer g.tmu.Unlock()
+
+	for repaired := false; ; repaired = true {
+		g.mu.Lock()
+		end, _, err := g.snapshot(ctx)
+		fixes, trunc := g.fixes, g.truncations.Load()
+		g.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+
+		index, err := g.seekTime(ctx, end, fixes, trunc, t.UnixNano())
+		var cerr *CorruptionError
+		if repaired || !errors.As(err, &cerr) {
+			return index, err
+		}
+		g.mu.Lock()
+		if g.fixes == fixes {
+			err = g.fix(ctx, string(cerr.Kind))
+		}
+		g.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// seekTime brings the time index up to end and searches it
+func (g *Gian) seekTime(ctx context.Context, end int64, fixes int, trunc uint64, t int64) (uint64, error) {
+	g.fmu.RLock()
+	f, err := os.Open(g.filename)
+	g.fmu.RUnlock()
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, ErrNoRecord
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	ti := g.times
+	if ti == nil || ti.fixes != fixes || ti.trunc != trunc || ti.end > end {
+		ti = &timeIndex{fixes: fixes, trunc: trunc, next: 1}
+		g.times = ti
+	}
+	for ti.end < end {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		index, stamp, next, err := frameTime(f, ti.end, end)
+		if err != nil {
+			return 0, err
+		}
+		if index != ti.next {
+			return 0, &CorruptionError{Kind: CorruptWrongIndex, Offset: ti.end}
+		}
+		if stamp != 0 {
+			if ti.count%TIME_INDEX_INTERVAL == 0 {
+				ti.marks = append(ti.marks, timeMark{index: index, offset: ti.end, time: stamp})
+			}
+			ti.count++
+		}
+		ti.next++
+		ti.end = next
+	}
+
+	if len(ti.marks) == 0 {
+		return 0, ErrNoRecord
+	}
+	// the records at or after t start between the last mark before t and
+	// the next one
+	i := sort.Search(len(ti.marks), func(i int) bool { return ti.marks[i].time >= t })
+	pos := ti.marks[max(i-1, 0)].offset
+	for pos < end {
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+		index, stamp, next, err := frameTime(f, pos, end)
+		if err != nil {
+			return 0, err
+		}
+		if stamp != 0 && stamp >= t {
+			return uint64(index), nil
+		}
+		pos = next
+	}
+	return 0, ErrNoRecord
+}
+
+// frameTime reads the index and the commit time, 0 if none, of the frame
+// at pos without reading its data, next is the offset of the frame after
+func frameTime(f io.ReaderAt, pos, end int64) (index int, stamp int64, next int64, err error) {
+	readAt := func(p []byte, off int64) error {
+		if _, err := f.ReadAt(p, off); err != nil {
+			if err == io.EOF {
+				return &CorruptionError{Kind: CorruptTruncated, Offset: pos}
+			}
+			return err
+		}
+		return nil
+	}
+
+	// [ N ] [ Length ] [ --- data ---- ] [ Length ] [ CHECKSUM ]
+	head := [16]byte{}
+	if err := readAt(head[:12], pos); err != nil {
+		return 0, 0, 0, err
+	}
+	l, _ := frameLength(head[8:12])
+	next = pos + 8 + 4 + int64(l) + 4 + 4
+	if l > ONEGB || next > end {
+		return 0, 0, 0, &CorruptionError{Kind: CorruptWrongLength, Offset: pos}
+	}
+	tail := [4]byte{}
+	if err := readAt(tail[:], pos+12+int64(l)); err != nil {
+		return 0, 0, 0, err
+	}
+	if !bytes.Equal(tail[:], head[8:12]) {
+		return 0, 0, 0, &CorruptionError{Kind: CorruptWrongLength, Offset: pos}
+	}
+	index, flags := frameIndex(head[:8])
+	if flags&HEADERS == 0 {
+		return index, 0, next, nil
+	}
+
+	if l < 4 {
+		return 0, 0, 0, &CorruptionError{Kind: CorruptWrongLength, Offset: pos}
+	}
+	if err := readAt(head[12:], pos+12); err != nil {
+		return 0, 0, 0, err
+	}
+	bl := binary.BigEndian.Uint32(head[12:])
+	if bl > l-4 {
+		return 0, 0, 0, &CorruptionError{Kind: CorruptWrongLength, Offset: pos}
+	}
+	block := make([]byte, 4+bl)
+	copy(block, head[12:])
+	if err := readAt(block[4:], pos+16); err != nil {
+		return 0, 0, 0, err
+	}
+	h, _, err := splitHeaders(block)
+	if err != nil {
+		return 0, 0, 0, &CorruptionError{Kind: CorruptChecksum, Offset: pos}
+	}
+	return index, h.time, next, nil
+}
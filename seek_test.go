@@ -0,0 +1,154 @@
+package gian
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSeekTime(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_seek_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	if _, err := gian.SeekTime(time.Now()); err != ErrNoRecord {
+		t.Errorf("SHOULD BE NO RECORD, got %v", err)
+	}
+
+	start := time.Now()
+	for i := range 3 * TIME_INDEX_INTERVAL {
+		if i%5 == 0 {
+			gian.Append([]byte("plain"))
+		}
+		gian.AppendRecord(Record{Data: []byte("timed")})
+	}
+
+	stamps := map[uint64]time.Time{}
+	r, _ := gian.NewReader()
+	defer r.Close()
+	r.Filter(func(rec *Record) bool { return !rec.Time.IsZero() })
+	for {
+		rec, err := r.ReadRecord()
+		if err != nil {
+			break
+		}
+		stamps[rec.Index] = rec.Time
+	}
+	if len(stamps) != 3*TIME_INDEX_INTERVAL {
+		t.Fatalf("SHOULDEQ %d, got %d", 3*TIME_INDEX_INTERVAL, len(stamps))
+	}
+
+	if index, err := gian.SeekTime(start); err != nil || index != 2 {
+		t.Errorf("SHOULDEQ 2, got %d %v", index, err)
+	}
+	for index, stamp := range stamps {
+		if got, err := gian.SeekTime(stamp); err != nil || got != index {
+			t.Fatalf("SHOULDEQ %d, got %d %v", index, got, err)
+		}
+		want := index + 1
+		if _, ok := stamps[want]; !ok {
+			want++
+		}
+		if _, ok := stamps[want]; !ok {
+			continue
+		}
+		if got, err := gian.SeekTime(stamp.Add(time.Nanosecond)); err != nil || got != want {
+			t.Fatalf("SHOULDEQ %d, got %d %v", want, got, err)
+		}
+	}
+
+	// the index follows new records and truncations
+	last, _ := gian.CommittedIndex()
+	if _, err := gian.SeekTime(time.Now()); err != ErrNoRecord {
+		t.Errorf("SHOULD BE NO RECORD, got %v", err)
+	}
+	now := time.Now()
+	index, _ := gian.AppendRecord(Record{Data: []byte("later")})
+	if got, err := gian.SeekTime(now); err != nil || got != index {
+		t.Errorf("SHOULDEQ %d, got %d %v", index, got, err)
+	}
+	gian.TruncateTo(last)
+	if _, err := gian.SeekTime(now); err != ErrNoRecord {
+		t.Errorf("SHOULD BE NO RECORD, got %v", err)
+	}
+}
+
+func TestStampAfterRestart(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_seek_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	// as if the clock stepped back an hour since the last record
+	future := time.Now().Add(time.Hour)
+	gian := New(filename)
+	gian.appendCopy(context.Background(), &Record{Time: future, Data: []byte("ahead")})
+	gian.Append([]byte("plain"))
+	gian.Close()
+
+	gian = New(filename)
+	defer gian.Close()
+	index, err := gian.AppendRecord(Record{Data: []byte("now")})
+	if err != nil || index != 3 {
+		t.Fatalf("SHOULDEQ 3, got %d %v", index, err)
+	}
+	rec, err := gian.ReadRecordAt(index, -1)
+	if err != nil || !rec.Time.After(future) {
+		t.Errorf("SHOULD GO ON AFTER %v, got %v %v", future, rec, err)
+	}
+	if got, err := gian.SeekTime(future.Add(time.Nanosecond)); err != nil || got != 3 {
+		t.Errorf("SHOULDEQ 3, got %d %v", got, err)
+	}
+}
+
+func TestStampTime(t *testing.T) {
+	for _, group := range []bool{false, true} {
+		file, _ := os.CreateTemp("", "gian_seek_*.dat")
+		filename := file.Name()
+		defer os.Remove(filename)
+		defer os.Remove(filename + ".bak")
+
+		gian := NewWithOptions(filename, Options{StampTime: true, GroupCommit: group})
+		defer gian.Close()
+		large := bytes.Repeat([]byte("s"), STREAM_CHUNKSIZE+10)
+		gian.Write([]byte("written"))
+		gian.ForceCommit()
+		gian.Append([]byte("appended"))
+		gian.AppendWithKey("k", []byte("keyed"))
+		gian.AppendBatch([][]byte{[]byte("batch1"), []byte("batch2"), []byte("batch3")})
+		gian.AppendStream(bytes.NewReader(large))
+		last, _ := gian.CommittedIndex()
+		gian.AppendIfLast(last, []byte("iflast"))
+
+		want := []string{"written", "appended", "keyed", "batch1", "batch2", "batch3", string(large), "iflast"}
+		got := []*Record{}
+		for rec, err := range gian.Records(context.Background(), 1) {
+			if err != nil {
+				t.Fatalf("MUST READ, got %v", err)
+			}
+			got = append(got, rec)
+		}
+		if len(got) != len(want) {
+			t.Fatalf("SHOULDEQ %d records, got %d", len(want), len(got))
+		}
+		for i, rec := range got {
+			if string(rec.Data) != want[i] {
+				t.Errorf("SHOULDEQ %.10q, got %.10q", want[i], rec.Data)
+			}
+			if rec.Time.IsZero() || i > 0 && !rec.Time.After(got[i-1].Time) {
+				t.Errorf("MUST STAMP A LATER TIME on %d, got %v", rec.Index, rec.Time)
+			}
+			if index, err := gian.SeekTime(rec.Time); err != nil || index != rec.Index {
+				t.Errorf("SHOULDEQ %d, got %d %v", rec.Index, index, err)
+			}
+		}
+		if err := gian.TruncateTo(4); err == nil {
+			t.Errorf("MUST NOT TRUNCATE INSIDE A STAMPED BATCH")
+		}
+	}
+}
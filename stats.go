@@ -0,0 +1,219 @@
+package gian
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// upper bounds of the commit latency buckets
+var latencyBounds = []time.Duration{
+	50 * time.Microsecond,
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	1 * time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+}
+
+// Histogram counts observations per bucket, Counts[i] is the number of
+// observations in (Bounds[i-1], Bounds[i]], the last count holds everything
+// above the last bound
+type Histogram struct {
+	Bounds []time.Duration
+	Counts []int64
+	Sum    time.Duration
+	Count  int64
+}
+
+// Stats is a point in time view of a Gian
+type Stats struct {
+	CommittedIndex   int   // index of the last frame written, 0 until the file is loaded
+	MainBytes        int64 // size of the main file
+	BakBytes         int64 // size of the backup file
+	UncommittedBytes int   // bytes waiting in memory for the next commit
+
+	Commits       int64
+	CommitLatency Histogram
+	Fsyncs        int64
+	Reads         int64
+	Repairs       int64
+	Corruptions   map[CorruptionKind]int64
+
+	LastAutoCommit time.Time
+}
+
+type stats struct {
+	mu             sync.Mutex
+	commits        int64
+	latency        []int64
+	latencySum     time.Duration
+	fsyncs         int64
+	reads          int64
+	repairs        int64
+	corruptions    map[CorruptionKind]int64
+	lastAutoCommit time.Time
+}
+
+func newStats() *stats {
+	return &stats{
+		latency:     make([]int64, len(latencyBounds)+1),
+		corruptions: map[CorruptionKind]int64{},
+	}
+}
+
+func (s *stats) commit(latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commits++
+	s.latencySum += latency
+	s.latency[sort.Search(len(latencyBounds), func(i int) bool { return latency <= latencyBounds[i] })]++
+}
+
+func (s *stats) fsync() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fsyncs++
+}
+
+func (s *stats) read(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reads += int64(n)
+}
+
+func (s *stats) repair() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.repairs++
+}
+
+func (s *stats) corruption(kind CorruptionKind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.corruptions[kind]++
+}
+
+func (s *stats) autoCommit() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastAutoCommit = time.Now()
+}
+
+// Stats returns the counters of this Gian since it was created
+func (g *Gian) Stats() Stats {
+	g.mu.Lock()
+	st := Stats{
+		CommittedIndex:   g.lastWriteIndex,
+		MainBytes:        fileSize(g.filename),
+		BakBytes:         fileSize(g.filename + ".bak"),
+		UncommittedBytes: g.uncommitLength,
+	}
+	g.mu.Unlock()
+
+	s := g.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st.Commits = s.commits
+	st.CommitLatency = Histogram{
+		Bounds: latencyBounds,
+		Counts: append([]int64{}, s.latency...),
+		Sum:    s.latencySum,
+		Count:  s.commits,
+	}
+	st.Fsyncs = s.fsyncs
+	st.Reads = s.reads
+	st.Repairs = s.repairs
+	st.Corruptions = map[CorruptionKind]int64{}
+	for kind, n := range s.corruptions {
+		st.Corruptions[kind] = n
+	}
+	st.LastAutoCommit = s.lastAutoCommit
+	return st
+}
+
+// PublishExpvar exports Stats under name in expvar, so it shows up in
+// /debug/vars. Like expvar.Publish it panics if name is already used.
+func (g *Gian) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any { return g.Stats() }))
+}
+
+// MetricsHandler serves Stats in the Prometheus text format
+func (g *Gian) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		g.WriteMetrics(w)
+	})
+}
+
+// WriteMetrics writes Stats in the Prometheus text format, every sample is
+// labeled with the file name
+func (g *Gian) WriteMetrics(w io.Writer) error {
+	st := g.Stats()
+	label := fmt.Sprintf("file=%q", g.GetFileName())
+	var err error
+	metric := func(name, typ, help string) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+		}
+	}
+	sample := func(name, labels string, value any) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, "%s{%s} %v\n", name, labels, value)
+		}
+	}
+
+	metric("gian_committed_index", "gauge", "Index of the last committed frame.")
+	sample("gian_committed_index", label, st.CommittedIndex)
+	metric("gian_file_bytes", "gauge", "Size of each replica on disk.")
+	sample("gian_file_bytes", label+`,replica="main"`, st.MainBytes)
+	sample("gian_file_bytes", label+`,replica="bak"`, st.BakBytes)
+	metric("gian_uncommitted_bytes", "gauge", "Bytes waiting in memory for the next commit.")
+	sample("gian_uncommitted_bytes", label, st.UncommittedBytes)
+	metric("gian_commits_total", "counter", "Frames committed.")
+	sample("gian_commits_total", label, st.Commits)
+	metric("gian_fsyncs_total", "counter", "Fsync calls.")
+	sample("gian_fsyncs_total", label, st.Fsyncs)
+	metric("gian_reads_total", "counter", "Records read.")
+	sample("gian_reads_total", label, st.Reads)
+	metric("gian_repairs_total", "counter", "Repairs run.")
+	sample("gian_repairs_total", label, st.Repairs)
+
+	metric("gian_corruptions_total", "counter", "Corruptions detected by kind.")
+	kinds := make([]string, 0, len(st.Corruptions))
+	for kind := range st.Corruptions {
+		kinds = append(kinds, string(kind))
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		sample("gian_corruptions_total", fmt.Sprintf("%s,kind=%q", label, kind), st.Corruptions[CorruptionKind(kind)])
+	}
+
+	metric("gian_commit_duration_seconds", "histogram", "Time spent writing a frame to both replicas.")
+	cumulative := int64(0)
+	for i, bound := range st.CommitLatency.Bounds {
+		cumulative += st.CommitLatency.Counts[i]
+		sample("gian_commit_duration_seconds_bucket", fmt.Sprintf("%s,le=\"%g\"", label, bound.Seconds()), cumulative)
+	}
+	sample("gian_commit_duration_seconds_bucket", label+`,le="+Inf"`, st.CommitLatency.Count)
+	sample("gian_commit_duration_seconds_sum", label, st.CommitLatency.Sum.Seconds())
+	sample("gian_commit_duration_seconds_count", label, st.CommitLatency.Count)
+
+	if !st.LastAutoCommit.IsZero() {
+		metric("gian_last_autocommit_timestamp_seconds", "gauge", "Last time the auto commit ticker ran.")
+		sample("gian_last_autocommit_timestamp_seconds", label, st.LastAutoCommit.Unix())
+	}
+	return err
+}
@@ -0,0 +1,93 @@
+package gian
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_stats_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	N := 10
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i))
+		gian.Write(b[:])
+		gian.ForceCommit()
+	}
+	gian.Write([]byte("pending"))
+
+	st := gian.Stats()
+	if st.CommittedIndex != N || st.Commits != int64(N) || st.CommitLatency.Count != int64(N) {
+		t.Errorf("SHOULDEQ %d, got %+v", N, st)
+	}
+	if st.MainBytes != int64(N*24) || st.BakBytes != st.MainBytes {
+		t.Errorf("SHOULDEQ %d, got %d %d", N*24, st.MainBytes, st.BakBytes)
+	}
+	if st.UncommittedBytes != len("pending") {
+		t.Errorf("SHOULDEQ, got %d", st.UncommittedBytes)
+	}
+	total := int64(0)
+	for _, n := range st.CommitLatency.Counts {
+		total += n
+	}
+	if total != int64(N) {
+		t.Errorf("SHOULDEQ, got %d, want %d", total, N)
+	}
+
+	cutFileTail(filename, 10)
+	if err := gian.Fix(); err != nil {
+		panic(err)
+	}
+	if _, err := gian.Read(); err != nil {
+		panic(err)
+	}
+	st = gian.Stats()
+	if st.Repairs != 1 || st.Corruptions[CorruptTruncated] != 1 || st.Fsyncs != 3 || st.Reads != 1 {
+		t.Errorf("SHOULD COUNT REPAIR %+v", st)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_metrics_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	gian.Write([]byte("hello"))
+	gian.ForceCommit()
+
+	rec := httptest.NewRecorder()
+	gian.MetricsHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, line := range []string{
+		`gian_commits_total{file="` + filename + `"} 1`,
+		`gian_file_bytes{file="` + filename + `",replica="bak"} 25`,
+		`gian_commit_duration_seconds_bucket{file="` + filename + `",le="+Inf"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("MISSING %s IN\n%s", line, body)
+		}
+	}
+
+	gian.PublishExpvar("gian_metrics_test")
+	st := Stats{}
+	if err := json.Unmarshal([]byte(expvar.Get("gian_metrics_test").String()), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Commits != 1 {
+		t.Errorf("SHOULDEQ, got %d", st.Commits)
+	}
+}
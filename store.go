@@ -0,0 +1,302 @@
+package gian
+
+import (
+	"container/list"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DEFAULT_MAX_OPEN is the number of streams of a Store whose files stay
+// open when StoreOptions.MaxOpen is 0
+const DEFAULT_MAX_OPEN = 64
+
+// ErrStreamExists is returned when renaming a stream onto another one
+var ErrStreamExists = errors.New("stream already exists")
+
+// StoreOptions configures a Store, the zero value is a good default
+type StoreOptions struct {
+	// options of every stream
+	Options Options
+	// streams whose files stay open, the least recently used ones are
+	// closed past it, 0 means DEFAULT_MAX_OPEN. It is a soft limit, a
+	// stream being written when it should be closed keeps its files until
+	// the next stream opens or the next commit tick, so each writer, the
+	// commit tick included, may hold one more stream open for a moment.
+	MaxOpen int
+	// how often the writes buffered by every stream are committed, 0
+	// means every 30 seconds like a Gian on its own
+	CommitInterval time.Duration
+}
+
+// Store keeps many named streams, each a Gian, in one directory. Streams
+// are opened on first use and share one goroutine committing their
+// buffered writes. A Gian returned by Stream stays valid until the stream
+// is deleted or renamed or the Store closed, its writes fail with
+// ErrClosed after. When its files were closed to stay under MaxOpen they
+// are opened again by its next write, which closes the files of the least
+// recently used stream instead.
+type Store struct {
+	// lock order is mu, then the lock of a stream, then lruMu
+	mu       sync.Mutex
+	dir      string
+	opts     StoreOptions
+	streams  map[string]*storeStream
+	closed   bool
+	stopChan chan struct{}
+	done     chan struct{}
+
+	lruMu sync.Mutex
+	lru   *list.List // streams whose files are open, most recent first
+}
+
+type storeStream struct {
+	name string
+	g    *Gian
+	elem *list.Element // nil while its files are closed, guarded by lruMu
+}
+
+// OpenStore opens the Store kept in dir, creating dir if needed
+func OpenStore(dir string, opts StoreOptions) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if opts.MaxOpen <= 0 {
+		opts.MaxOpen = DEFAULT_MAX_OPEN
+	}
+	if opts.CommitInterval <= 0 {
+		opts.CommitInterval = 30 * time.Second
+	}
+	s := &Store{
+		dir:      dir,
+		opts:     opts,
+		streams:  map[string]*storeStream{},
+		lru:      list.New(),
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go s.committer()
+	return s, nil
+}
+
+func (s *Store) path(name string) string {
+	return filepath.Join(s.dir, name+".gian")
+}
+
+// validName tells whether name can be a stream, it may not hold a path
+// separator or a dot, which separates the sidecars of a log
+func validName(name string) bool {
+	return name != "" && !strings.ContainsAny(name, `./\`)
+}
+
+// Stream returns the stream called name, created by its first write
+func (s *Store) Stream(name string) (*Gian, error) {
+	if !validName(name) {
+		return nil, errors.New("invalid stream name")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+
+	st, ok := s.streams[name]
+	if !ok {
+		st = &storeStream{name: name, g: newGian(s.path(name), s.opts.Options)}
+		st.g.onOpen = func() { s.opened(st) }
+		s.streams[name] = st
+	}
+	s.lruMu.Lock()
+	if st.elem != nil {
+		s.lru.MoveToFront(st.elem)
+	}
+	s.lruMu.Unlock()
+	return st.g, nil
+}
+
+// opened is called by a stream, holding its lock, once its files are
+// open. It closes the files of the least recently used streams past
+// MaxOpen, a stream busy writing keeps them until the next one opens or
+// the next commit tick.
+func (s *Store) opened(st *storeStream) {
+	s.lruMu.Lock()
+	if st.elem == nil {
+		st.elem = s.lru.PushFront(st)
+	} else {
+		s.lru.MoveToFront(st.elem)
+	}
+	s.lruMu.Unlock()
+	// waiting for the lock of another stream while holding the one of st
+	// could deadlock with it evicting st
+	s.evict(false)
+}
+
+// evict closes the files of the least recently used streams past MaxOpen,
+// without wait it skips the streams whose lock is taken
+func (s *Store) evict(wait bool) {
+	s.lruMu.Lock()
+	victims := []*storeStream{}
+	for e := s.lru.Back(); e != nil && s.lru.Len()-len(victims) > s.opts.MaxOpen; e = e.Prev() {
+		victims = append(victims, e.Value.(*storeStream))
+	}
+	s.lruMu.Unlock()
+
+	for _, old := range victims {
+		if wait {
+			old.g.mu.Lock()
+		} else if !old.g.mu.TryLock() {
+			continue
+		}
+		old.g.closeWriters()
+		s.drop(old)
+		old.g.mu.Unlock()
+	}
+}
+
+// List returns the names of the streams in the directory, sorted
+func (s *Store) List() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".gian")
+		if ok && !e.IsDir() && validName(name) {
+			names = append(names, name)
+		}
+	}
+	s.mu.Lock()
+	for name := range s.streams {
+		if !slices.Contains(names, name) {
+			// opened but not written yet
+			names = append(names, name)
+		}
+	}
+	s.mu.Unlock()
+	slices.Sort(names)
+	return names, nil
+}
+
+// Delete closes the stream called name and removes its files, sidecars
+// included
+func (s *Store) Delete(name string) error {
+	if !validName(name) {
+		return errors.New("invalid stream name")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	s.forget(name)
+	return removeLog(s.path(name))
+}
+
+// Rename closes the stream called from and moves its files, sidecars
+// included, to the stream called to, which must not exist
+func (s *Store) Rename(from, to string) error {
+	if !validName(from) || !validName(to) {
+		return errors.New("invalid stream name")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if _, ok := s.streams[to]; ok {
+		return ErrStreamExists
+	}
+	if _, err := os.Stat(s.path(to)); !errors.Is(err, os.ErrNotExist) {
+		if err == nil {
+			return ErrStreamExists
+		}
+		return err
+	}
+	s.forget(from)
+	files, err := logFiles(s.path(from))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return os.ErrNotExist
+	}
+	return renameLog(s.path(from), s.path(to))
+}
+
+// forget closes the stream called name if it is open, its Gian fails
+// writes with ErrClosed after
+func (s *Store) forget(name string) {
+	st, ok := s.streams[name]
+	if !ok {
+		return
+	}
+	delete(s.streams, name)
+	st.g.Close()
+	s.drop(st)
+}
+
+// drop takes st out of the streams whose files are open
+func (s *Store) drop(st *storeStream) {
+	s.lruMu.Lock()
+	defer s.lruMu.Unlock()
+	if st.elem != nil {
+		s.lru.Remove(st.elem)
+		st.elem = nil
+	}
+}
+
+// Close commits and closes every stream
+func (s *Store) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.stopChan)
+	s.mu.Unlock()
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for name, st := range s.streams {
+		if cerr := st.g.Close(); err == nil {
+			err = cerr
+		}
+		s.drop(st)
+		delete(s.streams, name)
+	}
+	return err
+}
+
+// committer commits the writes buffered by every stream, like autoCommit
+// does for a Gian on its own
+func (s *Store) committer() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.CommitInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.stopChan:
+			return
+		}
+		s.mu.Lock()
+		streams := make([]*Gian, 0, len(s.streams))
+		for _, st := range s.streams {
+			streams = append(streams, st.g)
+		}
+		s.mu.Unlock()
+		for _, g := range streams {
+			g.commitPending()
+		}
+		// the streams skipped by opened while they were busy
+		s.evict(true)
+	}
+}
@@ -0,0 +1,191 @@
+package gian
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestStore(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_store_*")
+	defer os.RemoveAll(dir)
+
+	s, err := OpenStore(dir, StoreOptions{MaxOpen: 2, CommitInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{"a", "b", "c", "d", "e"}
+	for i, name := range names {
+		g, err := s.Stream(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if index, err := g.Append([]byte(fmt.Sprint(i))); err != nil || index != 1 {
+			t.Errorf("SHOULDEQ 1, got %d %v", index, err)
+		}
+	}
+	countOpen := func() int {
+		open := 0
+		for _, st := range s.streams {
+			if st.g.wfile != nil {
+				open++
+			}
+		}
+		return open
+	}
+	if open := countOpen(); open > 2 {
+		t.Errorf("SHOULD KEEP 2 OPEN, got %d", open)
+	}
+	if _, err := s.Stream("../x"); err == nil {
+		t.Errorf("MUST REJECT THE NAME")
+	}
+
+	// handles kept by the caller reopen their files within MaxOpen
+	handles := []*Gian{}
+	for _, name := range names {
+		g, _ := s.Stream(name)
+		handles = append(handles, g)
+	}
+	for i, g := range handles {
+		if index, err := g.Append([]byte("again")); err != nil || index != 2 {
+			t.Errorf("SHOULDEQ 2, got %d %v", index, err)
+		}
+		if open := countOpen(); open > 2 {
+			t.Errorf("SHOULD KEEP 2 OPEN AFTER %s, got %d", names[i], open)
+		}
+	}
+
+	// buffered writes are committed by the store
+	g, _ := s.Stream("b")
+	g.Write([]byte("buffered"))
+	time.Sleep(100 * time.Millisecond)
+	if index, err := ReadFromStart(filepath.Join(dir, "b.gian"), nil); err != nil || index != 3 {
+		t.Errorf("SHOULD BE COMMITTED, got %d %v", index, err)
+	}
+
+	c, _ := g.NewConsumer("q")
+	c.Next()
+	c.Next()
+	c.Ack(2)
+	if err := s.Rename("b", "f"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rename("c", "f"); err != ErrStreamExists {
+		t.Errorf("SHOULD EXIST, got %v", err)
+	}
+	if err := s.Rename("missing", "g"); !os.IsNotExist(err) {
+		t.Errorf("SHOULD NOT EXIST, got %v", err)
+	}
+	if err := s.Delete("d"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := handles[3].Append([]byte("deleted")); err != ErrClosed {
+		t.Errorf("SHOULD BE CLOSED, got %v", err)
+	}
+	if err := handles[3].Write([]byte("deleted")); err != ErrClosed {
+		t.Errorf("SHOULD BE CLOSED, got %v", err)
+	}
+	if err := handles[1].Write([]byte("renamed")); err != ErrClosed {
+		t.Errorf("SHOULD BE CLOSED, got %v", err)
+	}
+	if list, _ := s.List(); !slices.Equal(list, []string{"a", "c", "e", "f"}) {
+		t.Errorf("SHOULDEQ a c e f, got %v", list)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.gian.consumer.q")); !os.IsNotExist(err) {
+		t.Errorf("MUST MOVE THE SIDECARS, got %v", err)
+	}
+
+	s, _ = OpenStore(dir, StoreOptions{})
+	defer s.Close()
+	g, _ = s.Stream("f")
+	out, err := g.ReadAll()
+	if err != nil || string(out) != "bufferedagain1" {
+		t.Errorf("SHOULDEQ bufferedagain1, got %q %v", out, err)
+	}
+	c, err = g.NewConsumer("q")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec, err := c.Next(); err != nil || string(rec.Data) != "buffered" {
+		t.Errorf("SHOULD RESUME, got %v", err)
+	}
+	files, _ := os.ReadDir(dir)
+	for _, f := range files {
+		if f.Name() == "d.gian" || f.Name() == "d.gian.bak" {
+			t.Errorf("MUST DELETE %s", f.Name())
+		}
+	}
+}
+
+func TestStoreConcurrent(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "gian_store_*")
+	defer os.RemoveAll(dir)
+
+	s, err := OpenStore(dir, StoreOptions{MaxOpen: 1, CommitInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	openStreams := func() int {
+		s.lruMu.Lock()
+		defer s.lruMu.Unlock()
+		return s.lru.Len()
+	}
+	stop := make(chan struct{})
+	sampled := make(chan int)
+	go func() {
+		most := 0
+		for {
+			select {
+			case <-stop:
+				sampled <- most
+				return
+			default:
+			}
+			most = max(most, openStreams())
+		}
+	}()
+
+	// 4 writers each going round 3 streams of their own
+	done := make(chan struct{})
+	for i := range 4 {
+		go func() {
+			defer func() { done <- struct{}{} }()
+			for j := range 600 {
+				g, _ := s.Stream(fmt.Sprint("s", i, "-", j%3))
+				if _, err := g.Append([]byte("x")); err != nil {
+					t.Error(err)
+					return
+				}
+				g.Write([]byte("y"))
+			}
+		}()
+	}
+	for range 4 {
+		<-done
+	}
+	close(stop)
+	// MaxOpen, one per writer and one for the commit tick at most
+	if most := <-sampled; most > 1+4+1 {
+		t.Errorf("SHOULD KEEP AT MOST 6 OPEN, got %d", most)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if open := openStreams(); open > 1 {
+		t.Errorf("SHOULD BE BACK UNDER MaxOpen AFTER A TICK, got %d", open)
+	}
+	for i := range 4 {
+		for j := range 3 {
+			g, _ := s.Stream(fmt.Sprint("s", i, "-", j))
+			g.ForceCommit()
+			if index, err := g.CommittedIndex(); err != nil || index != 400 {
+				t.Errorf("SHOULDEQ 400, got %d %v", index, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,451 @@
+package gian
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+
+	"github.com/thanhpk/vdisk"
+)
+
+// STREAM_CHUNKSIZE is the largest frame AppendStream writes, readers of a
+// streamed record hold at most one frame in memory
+const STREAM_CHUNKSIZE = 1024 * 1024 // 1mb
+
+// ErrNoRecord is returned by OpenRecord when no record starts at the index
+var ErrNoRecord = errors.New("no record starts at this index")
+
+// AppendStream commits everything read from r as one record, split over as
+// many frames as needed. All frames but the last carry CONTINUED. It
+// returns the index of the first frame, which is the index of the record.
+// Other writers wait until the stream ends. When r fails the frames written
+// so far are removed.
+func (g *Gian) AppendStream(r io.Reader) (uint64, error) {
+	return g.AppendStreamContext(context.Background(), r)
+}
+
+// AppendStreamContext is AppendStream that gives up between two frames
+// when ctx is done
+func (g *Gian) AppendStreamContext(ctx context.Context, r io.Reader) (index uint64, err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if err := g.forceCommit(ctx); err != nil {
+		return 0, err
+	}
+	if err := g.load(ctx); err != nil {
+		return 0, err
+	}
+	if err := g.openWriters(); err != nil {
+		return 0, err
+	}
+
+	mainSize, bakSize := fileSize(g.filename), fileSize(g.filename+".bak")
+	defer func() {
+		if err != nil {
+			// the stream was never acknowledged, drop its frames
+			os.Truncate(g.filename, mainSize)
+			os.Truncate(g.filename+".bak", bakSize)
+			g.loaded = false
+		}
+	}()
+
+	last, checksum, total := g.lastWriteIndex, g.lastCheckSum, 0
+	chunk := make([]byte, STREAM_CHUNKSIZE)
+	next := make([]byte, STREAM_CHUNKSIZE)
+	buf := make([]byte, 0, 8+4+STREAM_CHUNKSIZE+4+4)
+	n, rerr := io.ReadFull(r, chunk)
+	for {
+		if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+			return 0, rerr
+		}
+		length := uint32(n)
+		m := 0
+		if rerr == nil {
+			// a full chunk, the stream only ends here if nothing follows
+			m, rerr = io.ReadFull(r, next)
+			if m > 0 || rerr != io.EOF {
+				length |= CONTINUED
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return 0, err
+		}
+
+		// the commit time goes in the first frame, with the headers
+		frame, flags := chunk[:n], uint64(0)
+		if last == g.lastWriteIndex {
+			if frame, flags, err = g.stampFrame(frame); err != nil {
+				return 0, err
+			}
+			length += uint32(len(frame) - n)
+		}
+		last++
+		buf, checksum = encodeFrame(buf[:0], checksum, uint64(last)|flags, frame, length)
+		start := time.Now()
+		if _, err := g.wfile.Write(buf); err != nil {
+			return 0, err
+		}
+		if _, err := g.wbakfile.Write(buf); err != nil {
+			return 0, err
+		}
+		g.stats.commit(time.Since(start))
+		total += n
+
+		if length&CONTINUED == 0 {
+			break
+		}
+		chunk, next, n = next, chunk, m
+	}
+
+	if g.fsync {
+		if err := g.wfile.Sync(); err != nil {
+			return 0, err
+		}
+		g.stats.fsync()
+		if err := g.wbakfile.Sync(); err != nil {
+			return 0, err
+		}
+		g.stats.fsync()
+	}
+	first := g.lastWriteIndex + 1
+	g.committed(last, checksum, total)
+	return uint64(first), nil
+}
+
+// OpenRecord returns the record starting at index, as returned by Append
+// or AppendStream. Frames are verified one at a time before their data is
+// returned, so a streamed record is read in bounded memory.
+func (g *Gian) OpenRecord(index uint64) (io.ReadCloser, error) {
+	return g.OpenRecordContext(context.Background(), index)
+}
+
+// OpenRecordContext is OpenRecord whose reads give up once ctx is done
+func (g *Gian) OpenRecordContext(ctx context.Context, index uint64) (io.ReadCloser, error) {
+	g.mu.Lock()
+	end, top, err := g.snapshot(ctx)
+	fixes, trunc := g.fixes, g.truncations.Load()
+	g.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if index == 0 || index > uint64(top) {
+		return nil, ErrNoRecord
+	}
+
+	return g.openRecordReader(ctx, end, fixes, trunc, int(index), 0)
+}
+
+// openRecordReader returns a reader of the record starting at first, or of
+// every frame up to last when last is not 0
+func (g *Gian) openRecordReader(ctx context.Context, end int64, fixes int, trunc uint64, first, last int) (*recordReader, error) {
+	r := &recordReader{
+		g:     g,
+		ctx:   ctx,
+		end:   end,
+		fixes: fixes,
+		trunc: trunc,
+		first: first,
+		last:  last,
+		index: first,
+	}
+	if err := r.locate(); err != nil {
+		var cerr *CorruptionError
+		if !errors.As(err, &cerr) {
+			r.Close()
+			return nil, err
+		}
+		if _, err := r.repair(string(cerr.Kind)); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// recordReader reads the frames of one record forward, or of every record
+// up to a frame
+type recordReader struct {
+	g   *Gian
+	ctx context.Context
+
+	file  *vdisk.File
+	end   int64  // size of the main file when the record was opened
+	fixes int    // rewrites of the log seen by the reader
+	trunc uint64 // truncations of the log when the record was opened
+
+	first int     // index of the first frame of the record
+	last  int     // last frame to read, 0 stops at the end of the record
+	index int     // frame to read next
+	pos   int64   // where that frame starts
+	prev  [4]byte // checksum of the frame before it
+
+	frame   []byte
+	buf     []byte // verified data not returned yet
+	header  header // headers of the first frame of the record
+	start   int64  // where the first frame of the record starts
+	batched bool   // the last frame read carries BATCHED
+	done    bool
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if r.file == nil {
+			return 0, os.ErrClosed
+		}
+		if r.g.truncations.Load() != r.trunc {
+			return 0, ErrTruncated
+		}
+		if err := r.next(false); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *recordReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// nextRecord moves a reader done with its record to the record after it,
+// end and fixes are the size of the main file and its rewrites now
+func (r *recordReader) nextRecord(ctx context.Context, end int64, fixes int) error {
+	r.ctx, r.first, r.buf, r.done = ctx, r.index, nil, false
+	if fixes == r.fixes {
+		r.end = end
+		return nil
+	}
+	// the file was replaced, find the frame in the new one
+	r.end, r.fixes = end, fixes
+	if err := r.locate(); err != nil {
+		var cerr *CorruptionError
+		if !errors.As(err, &cerr) {
+			return err
+		}
+		if _, err := r.repair(string(cerr.Kind)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seek opens the main file at offset, where the frame at index should
+// start, and locates the frame if it does not
+func (r *recordReader) seek(offset int64) error {
+	r.Close()
+	r.g.fmu.RLock()
+	f, err := vdisk.NewLimiter(r.g.limitReadMbs).OpenFile(r.g.filename, os.O_RDONLY, 0644)
+	r.g.fmu.RUnlock()
+	if err != nil {
+		return err
+	}
+	r.file = f
+
+	relocate := func() error {
+		if err := r.locate(); err != nil {
+			var cerr *CorruptionError
+			if !errors.As(err, &cerr) {
+				return err
+			}
+			if _, err := r.repair(string(cerr.Kind)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if offset < 0 || offset+8+4+4+4 > r.end {
+		return relocate()
+	}
+	head := [8]byte{}
+	if _, err := f.ReadAt(head[:], offset); err != nil {
+		return err
+	}
+	if index, _ := frameIndex(head[:]); index != r.index {
+		return relocate()
+	}
+
+	r.pos, r.prev = offset, [4]byte{}
+	if offset == 0 {
+		return nil
+	}
+	// [ Length ] [ CHECKSUM ] of the frame before
+	b := [8]byte{}
+	if _, err := f.ReadAt(b[:], offset-8); err != nil {
+		return err
+	}
+	if _, continued := frameLength(b[:4]); continued && r.index == r.first {
+		return ErrNoRecord
+	}
+	copy(r.prev[:], b[4:])
+	return nil
+}
+
+// locate opens the main file and finds where the frame at index starts
+func (r *recordReader) locate() error {
+	r.Close()
+	r.g.fmu.RLock()
+	f, err := vdisk.NewLimiter(r.g.limitReadMbs).OpenFile(r.g.filename, os.O_RDONLY, 0644)
+	r.g.fmu.RUnlock()
+	if err != nil {
+		return err
+	}
+	r.file = f
+	pos, err := frameBoundary(f, r.end, r.index)
+	if err != nil {
+		return err
+	}
+	r.pos, r.prev = pos, [4]byte{}
+	if pos == 0 {
+		return nil
+	}
+
+	// [ Length ] [ CHECKSUM ] of the frame before
+	b := [8]byte{}
+	if _, err := f.ReadAt(b[:], pos-8); err != nil {
+		return err
+	}
+	if _, continued := frameLength(b[:4]); continued && r.index == r.first {
+		return ErrNoRecord
+	}
+	copy(r.prev[:], b[4:])
+	return nil
+}
+
+// next verifies the frame at pos and makes its data ready to be returned
+func (r *recordReader) next(repaired bool) error {
+	if err := r.ctx.Err(); err != nil {
+		return err
+	}
+	kind, err := r.readFrame()
+	if err != nil || kind == "" {
+		return err
+	}
+	if repaired {
+		return &CorruptionError{Kind: kind, Offset: r.pos}
+	}
+	fixed, err := r.repair(string(kind))
+	if err != nil {
+		return err
+	}
+	return r.next(fixed)
+}
+
+// readFrame reads the frame at pos, it returns the damage found if any
+func (r *recordReader) readFrame() (CorruptionKind, error) {
+	readAt := func(p []byte, off int64) (CorruptionKind, error) {
+		if _, err := r.file.ReadAt(p, off); err != nil {
+			if err == io.EOF {
+				return CorruptTruncated, nil
+			}
+			return "", err
+		}
+		return "", nil
+	}
+
+	// [ N ] [ Length ] [ --- data ---- ] [ Length ] [ CHECKSUM ]
+	if r.pos+8+4+4+4 > r.end {
+		return CorruptTruncated, nil
+	}
+	head := [12]byte{}
+	if kind, err := readAt(head[:], r.pos); kind != "" || err != nil {
+		return kind, err
+	}
+	l, continued := frameLength(head[8:])
+	if l > ONEGB || r.pos+8+4+int64(l)+4+4 > r.end {
+		return CorruptWrongLength, nil
+	}
+	index, flags := frameIndex(head[:8])
+	if index != r.index {
+		return CorruptWrongIndex, nil
+	}
+
+	if int(l) > len(r.frame) {
+		r.frame = make([]byte, l)
+	}
+	data := r.frame[:l]
+	if kind, err := readAt(data, r.pos+12); kind != "" || err != nil {
+		return kind, err
+	}
+	tail := [8]byte{}
+	if kind, err := readAt(tail[:], r.pos+12+int64(l)); kind != "" || err != nil {
+		return kind, err
+	}
+	if !bytes.Equal(tail[:4], head[8:]) {
+		return CorruptWrongLength, nil
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(r.prev[:])
+	crc.Write(head[:])
+	crc.Write(data)
+	crc.Write(tail[:4])
+	if binary.BigEndian.Uint32(tail[4:]) != crc.Sum32() {
+		return CorruptChecksum, nil
+	}
+	h := header{}
+	if flags&HEADERS != 0 {
+		var err error
+		if h, data, err = splitHeaders(data); err != nil {
+			return "", err
+		}
+	}
+	if index == r.first {
+		r.header, r.start = h, r.pos
+	}
+
+	copy(r.prev[:], tail[4:])
+	r.batched = flags&BATCHED != 0
+	r.pos += 8 + 4 + int64(l) + 4 + 4
+	r.index++
+	r.buf = data
+	r.done = !continued && (r.last == 0 || r.index > r.last)
+	if !continued {
+		r.g.stats.read(1)
+	}
+	return "", nil
+}
+
+// repair fixes both files, unless the log was rewritten since the record
+// was opened, then finds the frame to read next again
+func (r *recordReader) repair(reason string) (bool, error) {
+	g := r.g
+	g.mu.Lock()
+	if g.truncations.Load() != r.trunc {
+		g.mu.Unlock()
+		return false, ErrTruncated
+	}
+	fixed := g.fixes == r.fixes
+	var err error
+	if fixed {
+		err = g.fix(r.ctx, reason)
+	}
+	end, fixes := fileSize(g.filename), g.fixes
+	g.mu.Unlock()
+	if err != nil {
+		return fixed, err
+	}
+	r.end, r.fixes = end, fixes
+	return fixed, r.locate()
+}
+
+// frameLength splits a length field into the data length and whether the
+// record goes on in the next frame
+func frameLength(lenb []byte) (uint32, bool) {
+	l := binary.BigEndian.Uint32(lenb)
+	return l &^ CONTINUED, l&CONTINUED != 0
+}
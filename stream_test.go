@@ -0,0 +1,163 @@
+package gian
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func TestAppendStream(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_stream_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	gian.Append([]byte("before"))
+
+	big := make([]byte, 3*STREAM_CHUNKSIZE+STREAM_CHUNKSIZE/2)
+	rand.Read(big)
+	index, err := gian.AppendStream(bytes.NewReader(big))
+	if err != nil || index != 2 {
+		t.Fatalf("SHOULDEQ 2, got %d %v", index, err)
+	}
+	exact := big[:2*STREAM_CHUNKSIZE]
+	if index, err := gian.AppendStream(bytes.NewReader(exact)); err != nil || index != 6 {
+		t.Fatalf("SHOULDEQ 6, got %d %v", index, err)
+	}
+	if index, err := gian.AppendStream(bytes.NewReader(nil)); err != nil || index != 8 {
+		t.Fatalf("SHOULDEQ 8, got %d %v", index, err)
+	}
+	if index, _ := gian.Append([]byte("after")); index != 9 {
+		t.Fatalf("SHOULDEQ 9, got %d", index)
+	}
+	if index, err := ReadFromStart(filename, nil); err != nil || index != 9 {
+		t.Errorf("SHOULDEQ 9, got %d %v", index, err)
+	}
+
+	for index, want := range map[uint64][]byte{1: []byte("before"), 2: big, 6: exact, 8: {}, 9: []byte("after")} {
+		rc, err := gian.OpenRecord(index)
+		if err != nil {
+			t.Fatal(index, err)
+		}
+		out, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil || !bytes.Equal(out, want) {
+			t.Errorf("SHOULDEQ %d, got %d bytes %v", index, len(out), err)
+		}
+	}
+	if _, err := gian.OpenRecord(3); err != ErrNoRecord {
+		t.Errorf("SHOULD BE NO RECORD, got %v", err)
+	}
+	if _, err := gian.OpenRecord(10); err != ErrNoRecord {
+		t.Errorf("SHOULD BE NO RECORD, got %v", err)
+	}
+
+	r, _ := gian.NewReader()
+	defer r.Close()
+	for _, want := range [][]byte{[]byte("after"), {}, exact, big, []byte("before")} {
+		out, err := r.Read()
+		if err != nil || !bytes.Equal(out, want) {
+			t.Errorf("SHOULDEQ %d bytes, got %d %v", len(want), len(out), err)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("SHOULD BE EOF, got %v", err)
+	}
+}
+
+type failingReader struct {
+	n int
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if f.n == 0 {
+		return 0, errors.New("broken pipe")
+	}
+	n := min(len(p), f.n)
+	f.n -= n
+	return n, nil
+}
+
+func TestAppendStreamFailure(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_stream_failure_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	gian.Append([]byte("before"))
+	size := fileSize(filename)
+
+	if _, err := gian.AppendStream(&failingReader{n: 2*STREAM_CHUNKSIZE + 10}); err == nil {
+		t.Fatal("MUST FAIL")
+	}
+	if fileSize(filename) != size || fileSize(filename+".bak") != size {
+		t.Errorf("MUST DROP THE FRAMES, got %d", fileSize(filename))
+	}
+	if index, err := gian.Append([]byte("after")); err != nil || index != 2 {
+		t.Errorf("SHOULDEQ 2, got %d %v", index, err)
+	}
+}
+
+func TestUnfinishedStream(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_stream_unfinished_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	gian.Append([]byte("before"))
+	gian.AppendStream(bytes.NewReader(make([]byte, 2*STREAM_CHUNKSIZE+10)))
+	gian.Close()
+
+	// crash before the last frame of the stream
+	cutFileTail(filename, 10+20)
+	cutFileTail(filename+".bak", 10+20)
+
+	gian = New(filename)
+	defer gian.Close()
+	if index, err := gian.Append([]byte("after")); err != nil || index != 2 {
+		t.Errorf("SHOULDEQ 2, got %d %v", index, err)
+	}
+	out, err := gian.ReadAll()
+	if err != nil || string(out) != "afterbefore" {
+		t.Errorf("SHOULDEQ afterbefore, got %d bytes %v", len(out), err)
+	}
+}
+
+func TestOpenRecordHealing(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_stream_healing_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	big := make([]byte, 3*STREAM_CHUNKSIZE)
+	rand.Read(big)
+	gian.AppendStream(bytes.NewReader(big))
+
+	// flip a byte in the second frame of the main file
+	f, _ := os.OpenFile(filename, os.O_RDWR, 0644)
+	f.WriteAt([]byte{^big[STREAM_CHUNKSIZE+100]}, int64(STREAM_CHUNKSIZE+20+12+100))
+	f.Close()
+
+	rc, err := gian.OpenRecord(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	out, err := io.ReadAll(rc)
+	if err != nil || !bytes.Equal(out, big) {
+		t.Errorf("SHOULD HEAL, got %d bytes %v", len(out), err)
+	}
+	if checkSumFile(filename) != checkSumFile(filename+".bak") {
+		t.Errorf("MUST HEAL")
+	}
+}
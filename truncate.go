@@ -0,0 +1,182 @@
+package gian
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrTruncated is returned by readers opened before a TruncateTo
+var ErrTruncated = errors.New("gian was truncated")
+
+// TruncateTo drops every record after index, including data written but
+// not committed yet. Both files are cut right after the frame at index,
+// which may not be inside a streamed record or a batch. Readers opened
+// before return ErrTruncated, Read starts again from the newest record.
+//
+// The size to cut at is recorded in a journal first, so a crash in the
+// middle is finished by the next load instead of leaving the two files
+// disagreeing.
+func (g *Gian) TruncateTo(index uint64) error {
+	g.rmu.Lock()
+	defer g.rmu.Unlock()
+	if g.reader != nil {
+		g.reader.Close()
+		g.reader = nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ctx := context.Background()
+	if err := g.forceCommit(ctx); err != nil {
+		return err
+	}
+	if err := g.load(ctx); err != nil {
+		return err
+	}
+	if index > uint64(g.lastWriteIndex) {
+		return ErrNoRecord
+	}
+	if index == uint64(g.lastWriteIndex) {
+		return nil
+	}
+
+	f, err := os.Open(g.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	end, err := frameBoundary(f, fileSize(g.filename), int(index)+1)
+	if err != nil {
+		return err
+	}
+	checksum := uint32(0)
+	if end > 0 {
+		_, head, err := frameBefore(f, end)
+		if err != nil {
+			return err
+		}
+		_, flags := frameIndex(head[:8])
+		_, continued := frameLength(head[8:])
+		if flags&BATCHED != 0 || continued {
+			return errors.New("cannot truncate inside a record or a batch")
+		}
+		b := [4]byte{}
+		if _, err := f.ReadAt(b[:], end-4); err != nil {
+			return err
+		}
+		checksum = binary.BigEndian.Uint32(b[:])
+	}
+
+	if err := g.writeTruncateJournal(end); err != nil {
+		return err
+	}
+	g.truncations.Add(1)
+	if err := g.finishTruncate(); err != nil {
+		// the files are cut on the next load
+		g.loaded = false
+		return err
+	}
+	g.lastWriteIndex = int(index)
+	g.lastCheckSum = checksum
+	g.keys = nil
+	g.stampLoaded = false
+	return nil
+}
+
+func (g *Gian) truncateJournal() string {
+	return g.filename + ".truncate"
+}
+
+// writeTruncateJournal durably records the size both files are cut to
+func (g *Gian) writeTruncateJournal(size int64) error {
+	b := [8]byte{}
+	binary.BigEndian.PutUint64(b[:], uint64(size))
+	return replaceFile(g.truncateJournal(), b[:])
+}
+
+// replaceFile durably writes b to filename, the rename makes it appear
+// whole or not at all
+func replaceFile(filename string, b []byte) error {
+	tmp := filename + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	// the new name is only durable once the directory is
+	return syncFile(filepath.Dir(filename))
+}
+
+// syncFile flushes a file or a directory to disk
+func syncFile(filename string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// finishTruncate cuts both files to the size in the journal, if there is
+// one, then removes it
+func (g *Gian) finishTruncate() error {
+	b, err := os.ReadFile(g.truncateJournal())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if len(b) == 8 {
+		size := int64(binary.BigEndian.Uint64(b))
+		for _, filename := range []string{g.filename, g.filename + ".bak"} {
+			if err := truncateFile(filename, size); err != nil {
+				return err
+			}
+		}
+		g.stats.fsync()
+		g.stats.fsync()
+	}
+	return os.Remove(g.truncateJournal())
+}
+
+// truncateFile cuts filename to size and syncs it, a shorter or missing
+// file is left alone
+func truncateFile(filename string, size int64) error {
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0644)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() <= size {
+		return nil
+	}
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	return f.Sync()
+}
@@ -0,0 +1,117 @@
+package gian
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+)
+
+func TestTruncateTo(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_truncate_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	N := 10
+	for i := range N {
+		b := [4]byte{}
+		binary.BigEndian.PutUint32(b[:], uint32(i+1))
+		gian.Append(b[:])
+	}
+	gian.Write([]byte("pending"))
+	r, _ := gian.NewReader()
+	defer r.Close()
+	gian.Read()
+
+	if err := gian.TruncateTo(6); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := ReadFromStart(filename, nil); err != nil || index != 6 {
+		t.Errorf("SHOULDEQ 6, got %d %v", index, err)
+	}
+	if checkSumFile(filename) != checkSumFile(filename+".bak") {
+		t.Errorf("MUST BE IN SYNC")
+	}
+	if _, err := r.Read(); err != ErrTruncated {
+		t.Errorf("SHOULD BE TRUNCATED, got %v", err)
+	}
+	if out, err := gian.Read(); err != nil || binary.BigEndian.Uint32(out) != 6 {
+		t.Errorf("SHOULD READ FROM THE NEWEST, got %v %v", out, err)
+	}
+
+	if index, err := gian.Append([]byte("seven")); err != nil || index != 7 {
+		t.Errorf("SHOULDEQ 7, got %d %v", index, err)
+	}
+	if index, err := ReadFromStart(filename, nil); err != nil || index != 7 {
+		t.Errorf("MUST CHAIN, got %d %v", index, err)
+	}
+	if err := gian.TruncateTo(8); err != ErrNoRecord {
+		t.Errorf("SHOULD BE NO RECORD, got %v", err)
+	}
+	if err := gian.TruncateTo(0); err != nil {
+		t.Fatal(err)
+	}
+	if fileSize(filename) != 0 || fileSize(filename+".bak") != 0 {
+		t.Errorf("MUST BE EMPTY")
+	}
+	if index, _ := gian.Append([]byte("one")); index != 1 {
+		t.Errorf("SHOULDEQ 1, got %d", index)
+	}
+}
+
+func TestTruncateInsideStream(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_truncate_stream_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+
+	gian := New(filename)
+	defer gian.Close()
+	gian.Append([]byte("one"))
+	gian.AppendStream(bytes.NewReader(make([]byte, 2*STREAM_CHUNKSIZE+1)))
+	if err := gian.TruncateTo(3); err == nil {
+		t.Errorf("MUST NOT CUT A RECORD")
+	}
+	if err := gian.TruncateTo(1); err != nil {
+		t.Fatal(err)
+	}
+	if index, err := ReadFromStart(filename, nil); err != nil || index != 1 {
+		t.Errorf("SHOULDEQ 1, got %d %v", index, err)
+	}
+}
+
+func TestTruncateCrash(t *testing.T) {
+	file, _ := os.CreateTemp("", "gian_truncate_crash_*.dat")
+	filename := file.Name()
+	defer os.Remove(filename)
+	defer os.Remove(filename + ".bak")
+	defer os.Remove(filename + ".truncate")
+
+	gian := New(filename)
+	for i := range 10 {
+		gian.Append([]byte{byte(i)})
+	}
+	gian.Close()
+
+	// crash after the journal and the backup were written, main is still
+	// long and would win a repair
+	gian = New(filename)
+	gian.writeTruncateJournal(4 * 21)
+	truncateFile(filename+".bak", 4*21)
+	gian.Close()
+
+	gian = New(filename)
+	defer gian.Close()
+	if index, err := gian.Append([]byte("five")); err != nil || index != 5 {
+		t.Errorf("SHOULDEQ 5, got %d %v", index, err)
+	}
+	if checkSumFile(filename) != checkSumFile(filename+".bak") {
+		t.Errorf("MUST BE IN SYNC")
+	}
+	if _, err := os.Stat(filename + ".truncate"); !os.IsNotExist(err) {
+		t.Errorf("MUST REMOVE THE JOURNAL")
+	}
+}
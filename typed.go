@@ -0,0 +1,120 @@
+package gian
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+)
+
+// Codec turns values of T into record payloads and back
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(data []byte) (T, error)
+}
+
+// JSONCodec encodes values with encoding/json
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// GobCodec encodes values with encoding/gob, each record carries its own
+// type description so records decode on their own
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (GobCodec[T]) Decode(data []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v, err
+}
+
+// DecodeError is returned for a record that passed verification but that
+// the codec could not decode, unlike a CorruptionError the log is fine and
+// the records after it can still be read
+type DecodeError struct {
+	Index uint64
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("cannot decode record %d: %v", e.Index, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// TypedLog stores values of T in a Gian through a Codec
+type TypedLog[T any] struct {
+	g     *Gian
+	codec Codec[T]
+}
+
+func NewTypedLog[T any](g *Gian, codec Codec[T]) *TypedLog[T] {
+	return &TypedLog[T]{g: g, codec: codec}
+}
+
+// Gian returns the log the values are stored in
+func (l *TypedLog[T]) Gian() *Gian {
+	return l.g
+}
+
+// Append commits v as a record of its own and returns its index
+func (l *TypedLog[T]) Append(v T) (uint64, error) {
+	data, err := l.codec.Encode(v)
+	if err != nil {
+		return 0, err
+	}
+	return l.g.Append(data)
+}
+
+// All yields the values from the newest to the oldest, see NewReader. A
+// record the codec rejects yields a *DecodeError and the iteration goes on,
+// any other error is yielded last.
+func (l *TypedLog[T]) All(ctx context.Context) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		var zero T
+		r, err := l.g.NewReaderContext(ctx)
+		if err != nil {
+			yield(zero, err)
+			return
+		}
+		defer r.Close()
+		for {
+			rec, err := r.ReadRecordContext(ctx)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				yield(zero, err)
+				return
+			}
+			v, err := l.codec.Decode(rec.Data)
+			if err != nil {
+				err = &DecodeError{Index: rec.Index, Err: err}
+			}
+			if !yield(v, err) {
+				return
+			}
+		}
+	}
+}
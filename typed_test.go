@@ -0,0 +1,51 @@
+package gian
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+type event struct {
+	Name  string
+	Count int
+}
+
+func TestTypedLog(t *testing.T) {
+	for name, codec := range map[string]Codec[event]{"json": JSONCodec[event]{}, "gob": GobCodec[event]{}} {
+		file, _ := os.CreateTemp("", "gian_typed_*.dat")
+		filename := file.Name()
+		defer os.Remove(filename)
+		defer os.Remove(filename + ".bak")
+
+		gian := New(filename)
+		defer gian.Close()
+		log := NewTypedLog(gian, codec)
+		log.Append(event{"a", 1})
+		gian.Append([]byte("not an event"))
+		if index, err := log.Append(event{"b", 2}); err != nil || index != 3 {
+			t.Errorf("%s: SHOULDEQ 3, got %d %v", name, index, err)
+		}
+
+		got := []event{}
+		decodeErrors := []uint64{}
+		for v, err := range log.All(context.Background()) {
+			var derr *DecodeError
+			if errors.As(err, &derr) {
+				decodeErrors = append(decodeErrors, derr.Index)
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			got = append(got, v)
+		}
+		if len(got) != 2 || got[0] != (event{"b", 2}) || got[1] != (event{"a", 1}) {
+			t.Errorf("%s: SHOULDEQ b a, got %v", name, got)
+		}
+		if len(decodeErrors) != 1 || decodeErrors[0] != 2 {
+			t.Errorf("%s: SHOULD FAIL TO DECODE 2, got %v", name, decodeErrors)
+		}
+	}
+}